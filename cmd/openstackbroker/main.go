@@ -47,10 +47,35 @@ func main() {
 	}
 
 	for _, config := range brokerconfig.GetSubConfigArray("registry") {
+		cloud, err := adapters.LoadCloudConfig(config.GetString("cloud"), config.GetString("clouds_file"))
+		if err != nil {
+			log.Errorf(
+				"Failed to load cloud for %v Registry err - %v \n", config.GetString("name"), err)
+			os.Exit(1)
+		}
+
+		// Keys set on the registry take precedence over the cloud.
+		setting := func(key string, fallback string) string {
+			if value := config.GetString(key); value != "" {
+				return value
+			}
+			return fallback
+		}
+		// A domain set on the registry by name or id replaces both of the
+		// cloud
+		domainSetting := func(nameKey string, idKey string, name string, id string) (string, string) {
+			if config.GetString(nameKey) != "" || config.GetString(idKey) != "" {
+				return config.GetString(nameKey), config.GetString(idKey)
+			}
+			return name, id
+		}
+		userDomainName, userDomainID := domainSetting("user_domain_name", "user_domain_id", cloud.Auth.UserDomainName, cloud.Auth.UserDomainID)
+		projectDomainName, projectDomainID := domainSetting("project_domain_name", "project_domain_id", cloud.Auth.ProjectDomainName, cloud.Auth.ProjectDomainID)
+
 		rc := registries.Config{
-			URL:       config.GetString("url"),
-			User:      config.GetString("user"),
-			Pass:      config.GetString("pass"),
+			URL:       setting("url", cloud.Auth.AuthURL),
+			User:      setting("user", cloud.Auth.Username),
+			Pass:      setting("pass", cloud.Auth.Password),
 			Type:      config.GetString("type"),
 			Name:      config.GetString("name"),
 			Runner:    config.GetString("runner"),
@@ -58,21 +83,87 @@ func main() {
 			BlackList: []string{},
 		}
 
-		u, err := url.Parse(config.GetString("url"))
+		u, err := url.Parse(rc.URL)
 		if err != nil {
-			log.Errorf("url is not valid: %v", config.GetString("url"))
+			log.Errorf("url is not valid: %v", rc.URL)
 			// Default url, allow the registry to fail gracefully or un gracefully.
 			u = &url.URL{}
 		}
 		ac := bundleadapters.Configuration{
 			URL:    u,
-			User:   config.GetString("user"),
-			Pass:   config.GetString("pass"),
-			Runner: config.GetString("runner"),
-			Org:    config.GetString("project"),
+			User:   rc.User,
+			Pass:   rc.Pass,
+			Runner: rc.Runner,
+			Org:    setting("project", cloud.Auth.ProjectName),
 		}
 
-		oadapter := adapters.OpenstackAdapter{Config: ac}
+		httpClient, err := adapters.NewHTTPClient(adapters.TLSConfig{
+			CAFile:     setting("ca_file", cloud.CACert),
+			ClientCert: setting("client_cert", cloud.Cert),
+			ClientKey:  setting("client_key", cloud.Key),
+			Insecure:   config.GetBool("insecure") || cloud.Insecure(),
+		})
+		if err != nil {
+			log.Errorf(
+				"Failed to configure TLS for %v Registry err - %v \n", config.GetString("name"), err)
+			os.Exit(1)
+		}
+
+		authMethod := setting("auth_method", cloud.AuthType)
+		oidc := adapters.OIDCConfig{
+			IdentityProvider:    setting("identity_provider", cloud.Auth.IdentityProvider),
+			Protocol:            setting("protocol", cloud.Auth.Protocol),
+			DiscoveryEndpoint:   setting("discovery_endpoint", cloud.Auth.DiscoveryEndpoint),
+			AccessTokenEndpoint: setting("access_token_endpoint", cloud.Auth.AccessTokenEndpoint),
+			ClientID:            setting("client_id", cloud.Auth.ClientID),
+			ClientSecret:        setting("client_secret", cloud.Auth.ClientSecret),
+			Scope:               setting("openid_scope", cloud.Auth.OpenIDScope),
+			AccessToken:         setting("access_token", cloud.Auth.AccessToken),
+		}
+		credentials, err := adapters.NewCredentialSource(
+			config.GetString("auth_type"), config.GetString("auth_name"), brokerconfig.GetString("openshift.namespace"))
+		if err != nil {
+			log.Errorf(
+				"Failed to configure credentials for %v Registry err - %v \n", config.GetString("name"), err)
+			os.Exit(1)
+		}
+
+		if err := adapters.ValidateAuthMethod(authMethod, oidc, credentials); err != nil {
+			log.Errorf(
+				"Failed to configure authentication for %v Registry err - %v \n", config.GetString("name"), err)
+			os.Exit(1)
+		}
+
+		oadapter := adapters.OpenstackAdapter{
+			Config:                      ac,
+			Region:                      setting("region", cloud.RegionName),
+			Regions:                     config.GetSliceOfStrings("regions"),
+			Interface:                   setting("interface", cloud.Interface),
+			UserDomainName:              userDomainName,
+			UserDomainID:                userDomainID,
+			ProjectDomainName:           projectDomainName,
+			ProjectDomainID:             projectDomainID,
+			ApplicationCredentialID:     setting("application_credential_id", cloud.Auth.ApplicationCredentialID),
+			ApplicationCredentialName:   setting("application_credential_name", cloud.Auth.ApplicationCredentialName),
+			ApplicationCredentialSecret: setting("application_credential_secret", cloud.Auth.ApplicationCredentialSecret),
+			AuthMethod:                  authMethod,
+			OIDC:                        oidc,
+			Tokens:                      adapters.NewTokenManager(),
+			HTTPClient:                  httpClient,
+			Credentials:                 credentials,
+			CredentialsSecret:           config.GetString("credentials_secret"),
+			TemplateDir:                 config.GetString("template_dir"),
+			TemplateContainer:           config.GetString("template_container"),
+			FlavorTierPattern:           config.GetString("flavor_tier_pattern"),
+			FlavorTierExtraSpec:         config.GetString("flavor_tier_extra_spec"),
+			PageSize:                    config.GetInt("page_size"),
+			MaxItems:                    config.GetInt("max_items"),
+		}
+		if err := oadapter.ValidateRunnerCredentials(); err != nil {
+			log.Errorf(
+				"Failed to configure authentication for %v Registry err - %v \n", config.GetString("name"), err)
+			os.Exit(1)
+		}
 		reg, err := registries.NewCustomRegistry(rc, oadapter, "openstack")
 		if err != nil {
 			log.Errorf(
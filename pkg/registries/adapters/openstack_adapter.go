@@ -18,14 +18,12 @@ package adapters
 
 import (
 	"bytes"
-	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/automationbroker/bundle-lib/apb"
 	"github.com/automationbroker/bundle-lib/registries/adapters"
@@ -34,38 +32,218 @@ import (
 
 // OpenstackAdapter - Docker Hub Adapter
 type OpenstackAdapter struct {
-	Config adapters.Configuration
+	Config            adapters.Configuration
+	Region            string
+	Regions           []string
+	Interface         string
+	UserDomainName    string
+	UserDomainID      string
+	ProjectDomainName string
+	ProjectDomainID   string
+
+	ApplicationCredentialID     string
+	ApplicationCredentialName   string
+	ApplicationCredentialSecret string
+
+	// AuthMethod - how the registry identity authenticates, password by
+	// default or one of the v3oidc federation methods configured by OIDC
+	AuthMethod string
+	OIDC       OIDCConfig
+
+	// Tokens - optional cache of issued tokens shared across catalog refreshes
+	Tokens *TokenManager
+	// HTTPClient - client shared by every request, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// Credentials - optional secret or file the credentials are read from
+	Credentials *CredentialSource
+	// CredentialsSecret - when set, the broker secret handed to the runner
+	// instead of credential parameters
+	CredentialsSecret string
+	// TemplateDir, TemplateContainer - where the HOT templates of the heat
+	// service are read from, a local directory or a Swift container of the
+	// project
+	TemplateDir       string
+	TemplateContainer string
+	// FlavorTierPattern, FlavorTierExtraSpec - group flavors into a vm plan
+	// per tier, named by the first group of the pattern matched against the
+	// flavor name or by the value of the extra_spec
+	FlavorTierPattern   string
+	FlavorTierExtraSpec string
+	// PageSize - the number of items requested per page of a list, the
+	// service default if zero
+	PageSize int
+	// MaxItems - stop following the pages of a list once this many items
+	// have passed its filters, 1000 if zero
+	MaxItems int
 }
 
 type Object struct {
-	Name      string `json:"name"`
-	ProjectId string `json:"project_id,omitempty"`
+	ID         string `json:"id,omitempty"`
+	Name       string `json:"name"`
+	ProjectId  string `json:"project_id,omitempty"`
+	DomainId   string `json:"domain_id,omitempty"`
+	DiskFormat string `json:"disk_format,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	VCPUs      int    `json:"vcpus,omitempty"`
+	RAM        int    `json:"ram,omitempty"`
+	CIDR       string `json:"cidr,omitempty"`
+}
+
+// UnmarshalJSON - read an object, taking ids that are numbers, such as those
+// of older Trove flavors, as strings
+func (o *Object) UnmarshalJSON(data []byte) error {
+	type object Object
+	decoded := struct {
+		*object
+		ID json.RawMessage `json:"id"`
+	}{object: (*object)(o)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	o.ID = ""
+	if len(decoded.ID) > 0 && json.Unmarshal(decoded.ID, &o.ID) != nil {
+		o.ID = string(decoded.ID)
+	}
+	return nil
 }
 
 type Project struct {
-	ID string `json:"id"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
 }
 
 type Token struct {
-	Project Project `json:"project"`
+	User      TokenUser `json:"user"`
+	Project   Project   `json:"project"`
+	Catalog   Catalog   `json:"catalog"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type TokenUser struct {
+	ID     string     `json:"id"`
+	Domain authDomain `json:"domain"`
 }
 
 type TokenResponse struct {
 	Token Token `json:"token"`
 }
 
-const unscopedAuthString = "{ \"auth\": { \"identity\": { \"methods\": [\"password\"], \"password\": { \"user\": { \"name\": \"%v\", \"domain\": { \"id\": \"default\" }, \"password\": \"%v\" }}}}}"
-const scopedAuthString = "{ \"auth\": { \"identity\": { \"methods\": [\"password\"], \"password\": { \"user\": { \"name\": \"%v\", \"domain\": { \"id\": \"default\" }, \"password\": \"%v\" }}}, \"scope\": { \"project\": { \"name\": \"%v\",\"domain\": { \"id\": \"default\" }}}}}"
+var services = []string{"vm", "volume", "objectstore", "database", "loadbalancer", "network", "share", "k8s-cluster", "dns", "secret"}
+
+// serviceTypes - the catalog service type a service provisions with, a comma
+// separated list of alternative types. Every service also needs the
+// orchestration service, as its instances are Heat stacks.
+var serviceTypes = map[string]string{
+	"vm":           "compute",
+	"volume":       "volumev3,block-storage",
+	"objectstore":  "object-store",
+	"database":     "database",
+	"loadbalancer": "load-balancer",
+	"network":      "network",
+	"share":        "sharev2,shared-file-system",
+	"k8s-cluster":  "container-infra",
+	"dns":          "dns",
+	"secret":       "key-manager",
+}
 
-var services = []string{"vm"}
+// bindableServices - services whose runner returns credentials on bind
+var bindableServices = map[string]bool{
+	"vm":           true,
+	"volume":       true,
+	"objectstore":  true,
+	"database":     true,
+	"loadbalancer": true,
+	"share":        true,
+	"k8s-cluster":  true,
+	"secret":       true,
+}
 
+// updatableServices - services whose instances can be updated in place, to
+// any of their plans
+var updatableServices = map[string]bool{
+	"vm": true,
+}
+
+// parameterTypes - paths are relative to the endpoint of the catalog service
+// type, a comma separated list of alternative types, or to the API root in
+// front of its version with "root". "type" is the parameter type, enum unless
+// set, filled from the "name" list, labelled ids rather than names with "value"
+// id, or the comma separated "values" for enums, several of which may be
+// picked with "multiple", bounded by "minimum", "maximum" or the remaining
+// "quota" at "quota_path", less "quota_reserve", for integers and matched
+// against "pattern" for strings. The "quota" of an enum maps quota resources
+// to the object fields they limit, only objects within the quota limit are
+// offered. "display_type" is passed on to the form, for example password.
+// Entries with "bind" are bind parameters and entries with "updatable" may be
+// changed by an update. Broker provisioned instances are Heat stacks tagged
+// with openstack-broker and their service.
 var parameterTypes = map[string][]map[string]string{
 	"vm": {
-		{"name": "flavors", "label": "Flavor", "path": "/compute/v2/flavors", "required": "true"},
-		{"name": "keys", "label": "Key", "path": "/compute/v2/os-keypairs", "required": "false"},
-		{"name": "images", "label": "Image", "path": "/compute/v2/images", "required": "true"},
-		{"name": "networks", "label": "Network", "path": ":9696/v2.0/networks", "required": "true"},
-		{"name": "security_groups", "label": "Security Group", "path": "/compute/v2/os-security-groups", "required": "false"},
+		{"name": "flavors", "label": "Flavor", "service": "compute", "path": "/flavors/detail", "value": "id", "quota_path": "/os-quota-sets/{project_id}/detail", "quota": "cores:vcpus,ram:ram", "description": "Changing the flavor of an instance resizes it, if the remaining quota allows", "updatable": "true", "required": "true"},
+		{"name": "keys", "label": "Key", "service": "compute", "path": "/os-keypairs", "description": "A key pair is generated when none is picked", "required": "false"},
+		{"name": "images", "label": "Image", "service": "image", "path": "/v2/images", "value": "id", "required": "true"},
+		{"name": "networks", "label": "Network", "service": "network", "path": "/v2.0/networks", "value": "id", "required": "true"},
+		{"name": "security_groups", "label": "Security Group", "service": "compute", "path": "/os-security-groups", "updatable": "true", "required": "false"},
+		{"name": "instances", "label": "Volumes", "service": "orchestration", "path": "/stacks?tags=openstack-broker,volume", "default": "", "multiple": "true", "description": "Volume instances to attach.", "updatable": "true", "required": "false"},
+		{"name": "ssh_user", "label": "SSH User", "type": "string", "description": "Defaults to the user of the image's os_distro", "bind": "true", "required": "false"},
+		{"name": "kubernetes_service", "label": "Create Kubernetes Service", "type": "boolean", "default": "false", "description": "Create a Service and Endpoints for the instance in the binding namespace", "bind": "true", "required": "false"},
+		{"name": "service_port", "label": "Service Port", "type": "integer", "default": "22", "minimum": "1", "maximum": "65535", "bind": "true", "required": "false"},
+	},
+	"volume": {
+		{"name": "volume_types", "label": "Volume Type", "service": "volumev3,block-storage", "path": "/types", "required": "true"},
+		{"name": "availability_zones", "label": "Availability Zone", "service": "volumev3,block-storage", "path": "/os-availability-zone", "required": "false"},
+		{"name": "size", "label": "Size", "type": "integer", "description": "Size in GiB", "default": "1", "minimum": "1", "service": "volumev3,block-storage", "quota_path": "/os-quota-sets/{project_id}?usage=true", "quota": "gigabytes", "required": "true"},
+		{"name": "snapshots", "label": "Source Snapshot", "service": "volumev3,block-storage", "path": "/snapshots", "default": "", "required": "false"},
+		{"name": "images", "label": "Source Image", "service": "image", "path": "/v2/images", "value": "id", "default": "", "required": "false"},
+		{"name": "instances", "label": "Attach To Instance", "service": "orchestration", "path": "/stacks?tags=openstack-broker,vm", "default": "", "bind": "true", "required": "false"},
+	},
+	"objectstore": {
+		{"name": "container_name", "label": "Container Name", "type": "string", "description": "Generated when empty", "required": "false"},
+		{"name": "storage_policies", "label": "Storage Policy", "service": "object-store", "path": "/info", "root": "true", "required": "false"},
+		{"name": "acls", "label": "Access", "values": "private,public-read", "default": "private", "required": "true"},
+		{"name": "credential_types", "label": "Credential Type", "values": "ec2,tempurl", "default": "ec2", "description": "EC2/S3 credentials or a temp-URL key", "bind": "true", "required": "true"},
+	},
+	"database": {
+		{"name": "flavors", "label": "Flavor", "service": "database", "path": "/flavors", "value": "id", "required": "true"},
+		{"name": "size", "label": "Volume Size", "type": "integer", "default": "1", "minimum": "1", "description": "Size in GiB", "required": "true"},
+		{"name": "networks", "label": "Network", "service": "network", "path": "/v2.0/networks", "value": "id", "required": "false"},
+		{"name": "database_name", "label": "Database Name", "type": "string", "required": "true"},
+		{"name": "username", "label": "Username", "type": "string", "description": "Generated when empty", "bind": "true", "required": "false"},
+	},
+	"loadbalancer": {
+		{"name": "subnets", "label": "VIP Subnet", "service": "network", "path": "/v2.0/subnets", "value": "id", "required": "true"},
+		{"name": "flavors", "label": "Flavor", "service": "load-balancer", "path": "/v2/lbaas/flavors", "default": "", "required": "false"},
+		{"name": "listener_protocols", "label": "Listener Protocol", "values": "HTTP,HTTPS,TCP,UDP", "default": "HTTP", "required": "true"},
+		{"name": "listener_port", "label": "Listener Port", "type": "integer", "default": "80", "minimum": "1", "maximum": "65535", "required": "true"},
+		{"name": "lb_algorithms", "label": "Algorithm", "values": "ROUND_ROBIN,LEAST_CONNECTIONS,SOURCE_IP", "default": "ROUND_ROBIN", "required": "true"},
+		{"name": "instances", "label": "Pool Members", "service": "orchestration", "path": "/stacks?tags=openstack-broker,vm", "default": "", "multiple": "true", "required": "false"},
+		{"name": "member_port", "label": "Member Port", "type": "integer", "default": "80", "minimum": "1", "maximum": "65535", "required": "true"},
+		{"name": "external_networks", "label": "Floating IP Network", "service": "network", "path": "/v2.0/networks?router:external=true", "value": "id", "default": "", "description": "Allocate a floating IP for the VIP from this network", "required": "false"},
+	},
+	"network": {
+		{"name": "network_name", "label": "Network Name", "type": "string", "required": "true"},
+		{"name": "cidr", "label": "CIDR", "type": "string", "default": "10.0.0.0/24", "pattern": `^[0-9a-fA-F:.]+/[0-9]{1,3}$`, "required": "true"},
+		{"name": "ip_versions", "label": "IP Version", "values": "4,6", "default": "4", "required": "true"},
+		{"name": "dns_nameservers", "label": "DNS Servers", "type": "string", "description": "Comma separated addresses", "pattern": `^$|^[0-9a-fA-F:.]+(,[0-9a-fA-F:.]+)*$`, "required": "false"},
+		{"name": "external_networks", "label": "External Gateway", "service": "network", "path": "/v2.0/networks?router:external=true", "value": "id", "default": "", "description": "Create a router with a gateway on this network", "required": "false"},
+	},
+	"share": {
+		{"name": "share_types", "label": "Share Type", "service": "sharev2,shared-file-system", "path": "/types", "required": "true"},
+		{"name": "share_protocols", "label": "Protocol", "values": "NFS,CEPHFS", "default": "NFS", "required": "true"},
+		{"name": "share_networks", "label": "Share Network", "service": "sharev2,shared-file-system", "path": "/share-networks", "default": "", "required": "false"},
+		{"name": "size", "label": "Size", "type": "integer", "description": "Size in GiB", "default": "1", "minimum": "1", "service": "sharev2,shared-file-system", "quota_path": "/quota-sets/{project_id}/detail", "quota": "gigabytes", "required": "true"},
+		{"name": "access_types", "label": "Access Type", "values": "ip,cephx", "default": "ip", "description": "ip for NFS, cephx for CephFS", "bind": "true", "required": "true"},
+		{"name": "access_to", "label": "Access To", "type": "string", "description": "The cluster egress CIDR or the cephx identity to grant access to", "bind": "true", "required": "true"},
+		{"name": "access_levels", "label": "Access Level", "values": "rw,ro", "default": "rw", "bind": "true", "required": "true"},
+		{"name": "persistent_volume", "label": "Create Persistent Volume", "type": "boolean", "default": "true", "description": "Create a PersistentVolume for the share in the binding namespace", "bind": "true", "required": "false"},
+	},
+	"k8s-cluster": {
+		{"name": "keys", "label": "Key", "service": "compute", "path": "/os-keypairs", "required": "true"},
+		{"name": "master_count", "label": "Master Count", "type": "integer", "default": "1", "minimum": "1", "service": "compute", "quota_path": "/os-quota-sets/{project_id}/detail", "quota": "instances", "quota_reserve": "1", "description": "Masters and nodes together are limited by the remaining instances quota", "required": "true"},
+		{"name": "node_count", "label": "Node Count", "type": "integer", "default": "1", "minimum": "1", "service": "compute", "quota_path": "/os-quota-sets/{project_id}/detail", "quota": "instances", "quota_reserve": "1", "description": "Masters and nodes together are limited by the remaining instances quota", "required": "true"},
+	},
+	"dns": {
+		{"name": "ttl", "label": "TTL", "type": "integer", "default": "3600", "minimum": "1", "description": "Time to live in seconds", "required": "true"},
 	},
 }
 
@@ -82,12 +260,24 @@ func (r OpenstackAdapter) GetImageNames() ([]string, error) {
 	var apbNames []string
 	var projects []string
 
-	if len(r.Config.Org) == 0 {
-		token, err := r.getUnscopedToken()
+	if err := r.Credentials.Refresh(); err != nil {
+		return apbNames, err
+	}
+
+	if len(r.Config.Org) == 0 && r.usesApplicationCredential() {
+		// An application credential can only ever see the project it was
+		// created in.
+		_, scope, err := r.getScopedToken("")
+		if err != nil {
+			return apbNames, err
+		}
+		projects = append(projects, scope.Project.Name)
+	} else if len(r.Config.Org) == 0 {
+		domainID, err := r.resolveProjectDomainID()
 		if err != nil {
 			return apbNames, err
 		}
-		projects, err = r.getObjectList(token, "projects", "/identity/v3/auth/projects", "")
+		projects, err = r.getObjectList("", "projects", identityURL(r.Config.URL.String(), "/auth/projects"), domainID)
 		if err != nil {
 			return apbNames, err
 		}
@@ -96,14 +286,77 @@ func (r OpenstackAdapter) GetImageNames() ([]string, error) {
 	}
 
 	for _, project := range projects {
-		for _, service := range services {
-			apbNames = append(apbNames, fmt.Sprintf("openstack-%v-%v-project-apb", service, project))
+		projectServices := append([]string{}, services...)
+		templates, err := r.templateNames(project)
+		if err != nil {
+			log.Warningf("Could not retrieve templates for %v: %s", project, err)
+		}
+		for _, template := range templates {
+			projectServices = append(projectServices, templateImageService(template))
+		}
+		for _, service := range projectServices {
+			for _, region := range r.regions() {
+				apbNames = append(apbNames, imageName(service, project, region))
+			}
 		}
 	}
 
 	return apbNames, nil
 }
 
+// regions - the regions to generate specs for. A single empty region means
+// one spec per project using the configured region, if any.
+func (r OpenstackAdapter) regions() []string {
+	if len(r.Regions) > 0 {
+		return r.Regions
+	}
+	return []string{""}
+}
+
+// imageName - the name of the spec of a service in a project and region
+func imageName(service string, project string, region string) string {
+	if region == "" {
+		return fmt.Sprintf("openstack-%v-%v-project-apb", service, project)
+	}
+	return fmt.Sprintf("openstack-%v-%v-project-%v-region-apb", service, project, region)
+}
+
+// parseImageName - split an image name created by imageName back into its
+// service, project and region.
+func (r OpenstackAdapter) parseImageName(name string) (string, string, string, error) {
+	for _, service := range append([]string{templateService}, services...) {
+		prefix := fmt.Sprintf("openstack-%v-", service)
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		for _, region := range r.regions() {
+			suffix := strings.TrimPrefix(imageName(service, "", region), prefix)
+			if region != "" && strings.HasSuffix(rest, suffix) {
+				return service, strings.TrimSuffix(rest, suffix), region, nil
+			}
+		}
+		if strings.HasSuffix(rest, "-project-apb") {
+			return service, strings.TrimSuffix(rest, "-project-apb"), "", nil
+		}
+	}
+	return "", "", "", fmt.Errorf("%v is not an openstack image name", name)
+}
+
+// missingServiceType - the first service type a service needs that has no
+// endpoint in the catalog of the scope, empty when none is missing
+func (r OpenstackAdapter) missingServiceType(scope Token, service string, region string) string {
+	for _, serviceType := range []string{"orchestration", serviceTypes[service]} {
+		if serviceType == "" {
+			continue
+		}
+		if _, err := scope.Catalog.EndpointURL(serviceType, r.Interface, region); err != nil {
+			return serviceType
+		}
+	}
+	return ""
+}
+
 // FetchSpecs - retrieve the spec for the image names.
 func (r OpenstackAdapter) FetchSpecs(imageNames []string) ([]*apb.Spec, error) {
 	specs := []*apb.Spec{}
@@ -127,50 +380,109 @@ func (r OpenstackAdapter) loadSpec(imageName string) (*apb.Spec, error) {
 	var spec apb.Spec
 	var plan apb.Plan
 	var parameters []apb.ParameterDescriptor
-	splitName := strings.Split(imageName, "-")
-	splitlen := len(splitName)
-	service := splitName[1]
-	project := strings.Join(splitName[2:(splitlen-2)], "-")
-	displayName := fmt.Sprintf("Openstack %v in %v project (APB)", service, project)
+	var bindParameters []apb.ParameterDescriptor
+	service, project, region, err := r.parseImageName(imageName)
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = r.Region
+	}
+	serviceLabel := service
+	var template string
+	if service == templateService {
+		template, project, err = splitTemplateProject(project)
+		if err != nil {
+			return nil, err
+		}
+		serviceLabel = fmt.Sprintf("%v %v", service, template)
+	}
+	displayName := fmt.Sprintf("Openstack %v in %v project of %v domain (APB)", serviceLabel, project, r.projectDomainLabel())
+	if region != "" {
+		displayName = fmt.Sprintf("Openstack %v in %v project of %v domain, %v region (APB)", serviceLabel, project, r.projectDomainLabel(), region)
+	}
+	description := fmt.Sprintf("Provisions an Openstack %v instance in the %v Project of the %v Domain using a Heat Template", service, project, r.projectDomainLabel())
 
-	token, projectId, err := r.getScopedToken(project)
+	_, scope, err := r.getScopedToken(project)
 	if err != nil {
 		log.Warningf("Could not get a scoped token: %s", err)
+	} else if missing := r.missingServiceType(scope, service, region); missing != "" {
+		// Nobody could provision it
+		log.Infof("Leaving out %v, the catalog has no %v service", imageName, missing)
+		return nil, nil
 	}
 
-	//Configure Parameters
-	for _, pt := range parameterTypes[service] {
-		values, err := r.getObjectList(token, pt["name"], pt["path"], projectId)
+	if template != "" {
+		hot, file, err := r.loadTemplate(template, project, region)
 		if err != nil {
-			log.Warningf("Could not retrieve %s: %s", pt["name"], err)
+			return nil, err
 		}
-		required, err := strconv.ParseBool(pt["required"])
+		parameters, err = r.templateParameters(hot, project, region, scope)
 		if err != nil {
-			required = false
-		}
-
-		parameter := apb.ParameterDescriptor{
-			Name:      strings.Replace(strings.ToLower(pt["label"]), " ", "_", -1),
-			Title:     pt["label"],
-			Type:      "enum",
-			Updatable: false,
-			Enum:      values,
-			Required:  required,
+			return nil, err
 		}
-		if len(values) > 0 {
-			parameter.Default = values[0]
+		parameters = append(parameters, r.templateReference(file)...)
+		if hot.Description != "" {
+			description = strings.TrimSpace(hot.Description)
 		}
-		parameters = append(parameters, parameter)
+	}
 
+	//Configure Parameters
+	for _, pt := range parameterTypes[service] {
+		parameter := r.loadParameter(project, region, scope, pt)
+		if pt["bind"] == "true" {
+			bindParameters = append(bindParameters, parameter)
+		} else {
+			parameters = append(parameters, parameter)
+		}
 	}
 
-	authParameters := [5]map[string]string{
-		{"name": "url", "title": "URL", "default": fmt.Sprintf("%v/identity", r.Config.URL.String()), "type": "string", "displaytype": ""},
-		{"name": "user", "title": "User", "default": r.Config.User, "type": "string", "displaytype": ""},
-		{"name": "pass", "title": "Password", "default": r.Config.Pass, "type": "string", "displaytype": "password"},
+	userDomain := r.userDomain()
+	projectDomain := r.projectDomain()
+	authParameters := []map[string]string{
+		{"name": "url", "title": "URL", "default": r.Config.URL.String(), "type": "string", "displaytype": ""},
 		{"name": "project", "title": "Project", "default": project, "type": "string", "displaytype": ""},
 		{"name": "service", "title": "Service", "default": service, "type": "string", "displaytype": ""},
 	}
+	if region != "" {
+		authParameters = append(authParameters, map[string]string{"name": "region", "title": "Region", "default": region, "type": "string", "displaytype": ""})
+	}
+	// With a credentials secret the runner reads the credentials from the
+	// secret the broker mounts into its sandbox. Neither they nor the name of
+	// the secret are part of the catalog.
+	if r.usesFederation() {
+		// Federated identities always take their secrets from there
+		authParameters = append(authParameters, r.federationParameters()...)
+	} else if r.credentialsSecret() == "" {
+		switch {
+		case r.applicationCredentialID() != "":
+			authParameters = append(authParameters,
+				map[string]string{"name": "application_credential_id", "title": "Application Credential ID", "default": r.applicationCredentialID(), "type": "string", "displaytype": ""},
+				map[string]string{"name": "application_credential_secret", "title": "Application Credential Secret", "default": r.catalogSecret(r.applicationCredentialSecret()), "type": "string", "displaytype": "password"},
+			)
+		case r.ApplicationCredentialName != "":
+			authParameters = append(authParameters,
+				map[string]string{"name": "user", "title": "User", "default": r.user(), "type": "string", "displaytype": ""},
+				map[string]string{"name": "application_credential_name", "title": "Application Credential Name", "default": r.ApplicationCredentialName, "type": "string", "displaytype": ""},
+				map[string]string{"name": "application_credential_secret", "title": "Application Credential Secret", "default": r.catalogSecret(r.applicationCredentialSecret()), "type": "string", "displaytype": "password"},
+			)
+		default:
+			authParameters = append(authParameters,
+				map[string]string{"name": "user", "title": "User", "default": r.user(), "type": "string", "displaytype": ""},
+				map[string]string{"name": "pass", "title": "Password", "default": r.catalogSecret(r.pass()), "type": "string", "displaytype": "password"},
+			)
+		}
+	}
+	if userDomain.ID != "" {
+		authParameters = append(authParameters, map[string]string{"name": "user_domain_id", "title": "User Domain ID", "default": userDomain.ID, "type": "string", "displaytype": ""})
+	} else {
+		authParameters = append(authParameters, map[string]string{"name": "user_domain_name", "title": "User Domain", "default": userDomain.Name, "type": "string", "displaytype": ""})
+	}
+	if projectDomain.ID != "" {
+		authParameters = append(authParameters, map[string]string{"name": "project_domain_id", "title": "Project Domain ID", "default": projectDomain.ID, "type": "string", "displaytype": ""})
+	} else {
+		authParameters = append(authParameters, map[string]string{"name": "project_domain_name", "title": "Project Domain", "default": projectDomain.Name, "type": "string", "displaytype": ""})
+	}
 
 	for _, authParameter := range authParameters {
 		parameter := apb.ParameterDescriptor{
@@ -185,113 +497,332 @@ func (r OpenstackAdapter) loadSpec(imageName string) (*apb.Spec, error) {
 		}
 		parameters = append(parameters, parameter)
 	}
+	if r.credentialsSecret() != "" {
+		parameters = append(parameters, ownCredentialParameters()...)
+	}
 
-	//Configure Plan
-	plan.Name = "default"
-	plan.Parameters = parameters
-	plan.Description = fmt.Sprintf("Provisions an Openstack %v instance in the %v Project using a Heat Template", service, project)
+	//Configure Plans
+	servicePlans, err := r.loadPlans(service, project, region, scope)
+	if err != nil {
+		log.Warningf("Could not retrieve %s plans: %s", service, err)
+	}
+	for _, servicePlan := range servicePlans {
+		var planParameters []apb.ParameterDescriptor
+		planParameterNames := make(map[string]bool)
+		for _, pt := range servicePlan.Parameters {
+			parameter := r.loadParameterFrom(project, region, scope, pt, servicePlan.Objects[pt["name"]])
+			planParameters = append(planParameters, parameter)
+			planParameterNames[parameter.Name] = true
+		}
+		// Plan parameters replace the service parameters of the same name
+		for _, parameter := range parameters {
+			if !planParameterNames[parameter.Name] {
+				planParameters = append(planParameters, parameter)
+			}
+		}
+		spec.Plans = append(spec.Plans, apb.Plan{
+			Name:           servicePlan.Name,
+			Description:    servicePlan.Description,
+			Metadata:       servicePlan.Metadata,
+			Parameters:     planParameters,
+			BindParameters: bindParameters,
+		})
+	}
+	if len(spec.Plans) == 0 {
+		plan.Name = "default"
+		plan.Parameters = parameters
+		plan.BindParameters = bindParameters
+		plan.Description = description
+		spec.Plans = append(spec.Plans, plan)
+	}
+	if updatableServices[service] {
+		var planNames []string
+		for _, plan := range spec.Plans {
+			planNames = append(planNames, plan.Name)
+		}
+		for i := range spec.Plans {
+			spec.Plans[i].UpdatesTo = planNames
+		}
+	}
 
 	//Configure APB
 	spec.Runtime = 2
-	spec.Description = fmt.Sprintf("Provisions an Openstack %v instance in the %v Project using a Heat Template", service, project)
+	spec.Description = description
 	spec.Image = r.Config.Runner
 	spec.FQName = strings.Replace(imageName, "_", "-", -1)
 	spec.Version = "1.0"
-	spec.Bindable = false
+	spec.Bindable = bindableServices[service]
 	spec.Async = "optional"
 	spec.Metadata = map[string]interface{}{
 		"displayName":         displayName,
 		"providerDisplayName": "Red Hat, Inc.",
 	}
-	spec.Plans = append(spec.Plans, plan)
 
 	log.Warningf("leaving OpenstackAdapter.loadSpec(%s), returning %v", imageName, spec)
 	return &spec, nil
 }
 
-func (r OpenstackAdapter) getUnscopedToken() (string, error) {
-	authString := fmt.Sprintf(unscopedAuthString, r.Config.User, r.Config.Pass)
-	authBytes := []byte(authString)
-
-	authUrl := fmt.Sprintf("%v/identity/v3/auth/tokens",
-		r.Config.URL.String())
-
-	response, err := openstackRequest(authUrl, "POST", authBytes, "")
-	if err != nil {
-		return "", err
+// ownCredentialParameters - the optional parameters a user can fill in to
+// provision with their own credentials instead of the injected ones
+func ownCredentialParameters() []apb.ParameterDescriptor {
+	group := "Use My Own Openstack Credentials"
+	return []apb.ParameterDescriptor{
+		{
+			Name:         "use_own_credentials",
+			Title:        "Use my own credentials",
+			Type:         "boolean",
+			Default:      false,
+			DisplayGroup: group,
+		},
+		{
+			Name:         "own_user",
+			Title:        "User",
+			Type:         "string",
+			DisplayGroup: group,
+		},
+		{
+			Name:         "own_pass",
+			Title:        "Password",
+			Type:         "string",
+			DisplayType:  "password",
+			DisplayGroup: group,
+		},
 	}
-	defer response.Body.Close()
+}
 
-	return response.Header["X-Subject-Token"][0], nil
+// getScopedToken - return a cached token for the project, an empty project
+// is unscoped for passwords and the bound project for application credentials.
+func (r OpenstackAdapter) getScopedToken(project string) (string, Token, error) {
+	return r.Tokens.Get(project, func() (string, Token, error) {
+		return r.issueToken(project)
+	})
 }
 
-func (r OpenstackAdapter) getScopedToken(project string) (string, string, error) {
-	authString := fmt.Sprintf(scopedAuthString, r.Config.User, r.Config.Pass, project)
-	authBytes := []byte(authString)
+func (r OpenstackAdapter) issueToken(project string) (string, Token, error) {
+	// Tokens are issued between catalog refreshes, re-read credentials that
+	// may have been rotated since
+	if err := r.Credentials.Refresh(); err != nil {
+		log.Warningf("Could not refresh registry credentials, using the last read: %s", err)
+	}
 
-	authUrl := fmt.Sprintf("%v/identity/v3/auth/tokens",
-		r.Config.URL.String())
+	var authBytes []byte
+	var err error
+	var unscoped string
+	if r.usesFederation() {
+		if project == "" {
+			return r.issueFederatedToken()
+		}
+		// Federated identities are scoped by exchanging the unscoped token
+		unscoped, _, err = r.getScopedToken("")
+		if err != nil {
+			return "", Token{}, err
+		}
+		authBytes, err = r.tokenAuthBytes(unscoped, project)
+	} else {
+		authBytes, err = r.authBytes(project)
+	}
+	if err != nil {
+		return "", Token{}, err
+	}
 
-	response, err := openstackRequest(authUrl, "POST", authBytes, "")
+	authUrl := identityURL(r.Config.URL.String(), "/auth/tokens")
+
+	response, err := r.openstackRequest(authUrl, "POST", authBytes, "")
 	if err != nil {
-		return "", "", err
+		if isUnauthorized(err) && unscoped != "" {
+			r.Tokens.Invalidate("", unscoped)
+		}
+		return "", Token{}, err
 	}
 	defer response.Body.Close()
 
 	objectJson, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return "", "", err
+		return "", Token{}, err
 	}
 
 	objectResponse := TokenResponse{}
 	err = json.Unmarshal(objectJson, &objectResponse)
 	if err != nil {
-		return "", "", err
+		return "", Token{}, err
 	}
 
-	return response.Header["X-Subject-Token"][0], objectResponse.Token.Project.ID, nil
+	if r.usesApplicationCredential() && project != "" && objectResponse.Token.Project.Name != project {
+		return "", Token{}, fmt.Errorf("application credential is bound to project %v, not %v",
+			objectResponse.Token.Project.Name, project)
+	}
+
+	return response.Header.Get("X-Subject-Token"), objectResponse.Token, nil
 }
 
-func (r OpenstackAdapter) getObjectList(token string, objectType string, objectPath string, projectId string) ([]string, error) {
-	var objects []string
+// authenticatedRequest - perform a request with the token of the project,
+// re-authenticating once if the token is rejected.
+func (r OpenstackAdapter) authenticatedRequest(project string, requestUrl string, method string, data []byte) (*http.Response, error) {
+	return r.authenticatedRequestWithHeader(project, requestUrl, method, data, nil)
+}
+
+// authenticatedRequestWithHeader - an authenticated request with additional
+// headers, such as the microversion of an API
+func (r OpenstackAdapter) authenticatedRequestWithHeader(project string, requestUrl string, method string, data []byte, header http.Header) (*http.Response, error) {
+	token, _, err := r.getScopedToken(project)
+	if err != nil {
+		return nil, err
+	}
 
-	objectUrl := fmt.Sprintf("%v%v", r.Config.URL.String(), objectPath)
-	if objectType == "networks" {
-		objectUrl = strings.Replace(objectUrl, "https://", "http://", 1)
+	response, err := r.openstackRequestWithHeader(requestUrl, method, data, token, header)
+	if isUnauthorized(err) {
+		log.Infof("Token for project %q was rejected, re-authenticating", project)
+		r.Tokens.Invalidate(project, token)
+		token, _, err = r.getScopedToken(project)
+		if err != nil {
+			return nil, err
+		}
+		response, err = r.openstackRequestWithHeader(requestUrl, method, data, token, header)
 	}
+	return response, err
+}
 
-	response, err := openstackRequest(objectUrl, "GET", nil, token)
+// getObject - read a json response into an object
+func (r OpenstackAdapter) getObject(project string, objectUrl string, object interface{}) error {
+	response, err := r.authenticatedRequest(project, objectUrl, "GET", nil)
 	if err != nil {
-		return []string{}, err
+		return err
 	}
 	defer response.Body.Close()
 	objectJson, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(objectJson, object)
+}
+
+func (r OpenstackAdapter) getObjectList(project string, objectType string, objectUrl string, ownerId string) ([]string, error) {
+	var objects []string
+
+	objectArray, _, err := r.getObjects(project, objectType, objectUrl, ownerId, nil)
 	if err != nil {
 		return []string{}, err
 	}
+	objects = objectValues(objectArray, false)
 
+	return objects, nil
+}
+
+// getObjects - the objects of a list type that keep accepts, all of them if
+// keep is nil. Networks are limited to those of the ownerId project and
+// projects to those of the ownerId domain, if set. Returns true when the list
+// was cut short at the maximum number of items.
+func (r OpenstackAdapter) getObjects(project string, objectType string, objectUrl string, ownerId string, keep func(Object) bool) ([]Object, bool, error) {
+	var objects []Object
+	truncated, err := r.getPages(project, objectType, objectUrl, func(objectJson []byte) int {
+		kept := 0
+		for _, object := range r.parseObjects(objectType, objectJson, ownerId) {
+			if keep == nil || keep(object) {
+				objects = append(objects, object)
+				kept++
+			}
+		}
+		return kept
+	})
+	if len(objects) > r.maxItems() {
+		objects = objects[:r.maxItems()]
+		truncated = true
+	}
+	if err == nil && len(objects) == 0 {
+		log.Warningf("Did not find any %v", objectType)
+	}
+	return objects, truncated, err
+}
+
+// parseObjects - the objects of a page of a list type
+func (r OpenstackAdapter) parseObjects(objectType string, objectJson []byte, ownerId string) []Object {
 	var objectArray []Object
 	switch objectType {
 	case "keys":
 		objectResponse := make(map[string][]map[string]Object)
 		json.Unmarshal(objectJson, &objectResponse)
-		if len(objectResponse["keypairs"]) == 0 {
-			log.Warningf("Did not find any %v when unmarshalling response", objectType)
-		}
 		var objectList []Object
 		for _, object := range objectResponse["keypairs"] {
 			objectList = append(objectList, object["keypair"])
 		}
 		objectArray = objectList
-	case "networks":
+	case "projects":
 		objectResponse := make(map[string][]Object)
 		json.Unmarshal(objectJson, &objectResponse)
-		if len(objectResponse[objectType]) == 0 {
-			log.Warningf("Did not find any %v when unmarshalling response", objectType)
+		n := 0
+		for _, object := range objectResponse[objectType] {
+			if ownerId == "" || object.DomainId == ownerId {
+				objectResponse[objectType][n] = object
+				n++
+			}
 		}
+		objectResponse[objectType] = objectResponse[objectType][:n]
+		objectArray = objectResponse[objectType]
+	case "availability_zones":
+		objectResponse := struct {
+			Zones []struct {
+				ZoneName  string `json:"zoneName"`
+				ZoneState struct {
+					Available bool `json:"available"`
+				} `json:"zoneState"`
+			} `json:"availabilityZoneInfo"`
+		}{}
+		json.Unmarshal(objectJson, &objectResponse)
+		for _, zone := range objectResponse.Zones {
+			if zone.ZoneState.Available {
+				objectArray = append(objectArray, Object{Name: zone.ZoneName})
+			}
+		}
+	case "storage_policies":
+		objectResponse := struct {
+			Swift struct {
+				Policies []struct {
+					Name    string `json:"name"`
+					Default bool   `json:"default"`
+				} `json:"policies"`
+			} `json:"swift"`
+		}{}
+		json.Unmarshal(objectJson, &objectResponse)
+		// The default policy goes first so it becomes the parameter default
+		for _, policy := range objectResponse.Swift.Policies {
+			if policy.Default {
+				objectArray = append([]Object{{Name: policy.Name}}, objectArray...)
+			} else {
+				objectArray = append(objectArray, Object{Name: policy.Name})
+			}
+		}
+	case "instances":
+		objectResponse := make(map[string][]map[string]interface{})
+		json.Unmarshal(objectJson, &objectResponse)
+		for _, stack := range objectResponse["stacks"] {
+			if name, ok := stack["stack_name"].(string); ok {
+				objectArray = append(objectArray, Object{Name: name})
+			}
+		}
+	case "secrets", "containers":
+		// Barbican refers to secrets and containers by a url ending in
+		// their uuid
+		objectResponse := make(map[string][]map[string]interface{})
+		json.Unmarshal(objectJson, &objectResponse)
+		for _, item := range objectResponse[objectType] {
+			ref, _ := item[strings.TrimSuffix(objectType, "s")+"_ref"].(string)
+			name, _ := item["name"].(string)
+			if ref == "" {
+				continue
+			}
+			objectArray = append(objectArray, Object{ID: ref[strings.LastIndex(ref, "/")+1:], Name: name})
+		}
+	case "external_networks":
+		// External networks usually belong to another project
+		objectResponse := make(map[string][]Object)
+		json.Unmarshal(objectJson, &objectResponse)
+		objectArray = objectResponse["networks"]
+	case "networks":
+		objectResponse := make(map[string][]Object)
+		json.Unmarshal(objectJson, &objectResponse)
 		n := 0
 		for _, object := range objectResponse[objectType] {
-			if object.ProjectId == projectId {
+			if object.ProjectId == ownerId {
 				objectResponse[objectType][n] = object
 				n++
 			}
@@ -301,41 +832,69 @@ func (r OpenstackAdapter) getObjectList(token string, objectType string, objectP
 	default:
 		objectResponse := make(map[string][]Object)
 		json.Unmarshal(objectJson, &objectResponse)
-		if len(objectResponse[objectType]) == 0 {
-			log.Warningf("Did not find any %v when unmarshalling response", objectType)
-		}
 		objectArray = objectResponse[objectType]
 	}
 
-	for _, object := range objectArray {
-		objects = append(objects, object.Name)
+	return objectArray
+}
+
+// statusError - an unexpected http status returned by an Openstack service
+type statusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e statusError) Error() string {
+	return e.Status
+}
+
+func isUnauthorized(err error) bool {
+	serr, ok := err.(statusError)
+	return ok && serr.StatusCode == http.StatusUnauthorized
+}
+
+// isNotAcceptable - whether a service rejected the requested microversion
+func isNotAcceptable(err error) bool {
+	serr, ok := err.(statusError)
+	return ok && serr.StatusCode == http.StatusNotAcceptable
+}
+
+// httpClient - the client shared by every request of the adapter
+func (r OpenstackAdapter) httpClient() *http.Client {
+	if r.HTTPClient == nil {
+		return http.DefaultClient
 	}
+	return r.HTTPClient
+}
 
-	return objects, nil
+func (r OpenstackAdapter) openstackRequest(requestUrl string, method string, data []byte, token string) (*http.Response, error) {
+	return r.openstackRequestWithHeader(requestUrl, method, data, token, nil)
 }
 
-func openstackRequest(requestUrl string, method string, data []byte, token string) (*http.Response, error) {
+func (r OpenstackAdapter) openstackRequestWithHeader(requestUrl string, method string, data []byte, token string, header http.Header) (*http.Response, error) {
 	req, err := http.NewRequest(method, requestUrl, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
 	req.Header.Set("Content-Type", "application/json")
 	if len(token) != 0 {
 		req.Header.Set("X-Auth-Token", token)
 	}
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	httpClient := &http.Client{Transport: transport}
-
-	resp, err := httpClient.Do(req)
+	resp, err := r.httpClient().Do(req)
 	if err != nil {
+		if terr := tlsVerificationError(req.URL.Host, err); terr != nil {
+			return nil, terr
+		}
 		return nil, err
 	}
 
 	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return nil, errors.New(resp.Status)
+		resp.Body.Close()
+		return nil, statusError{StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 	response := resp
 
@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestImageNameRoundTrip(t *testing.T) {
+	regions := []string{"RegionOne", "us-east-1"}
+	cases := []struct {
+		service string
+		project string
+		region  string
+		regions []string
+		name    string
+	}{
+		{"vm", "demo", "", nil, "openstack-vm-demo-project-apb"},
+		{"k8s-cluster", "demo", "", nil, "openstack-k8s-cluster-demo-project-apb"},
+		{"network", "my-team-project", "", nil, "openstack-network-my-team-project-project-apb"},
+		{"volume", "demo", "RegionOne", regions, "openstack-volume-demo-project-RegionOne-region-apb"},
+		{"k8s-cluster", "web-dev", "us-east-1", regions, "openstack-k8s-cluster-web-dev-project-us-east-1-region-apb"},
+		{"database", "region-project", "us-east-1", regions, "openstack-database-region-project-project-us-east-1-region-apb"},
+		{templateImageService("web"), "demo", "", nil, "openstack-heat-web-template-demo-project-apb"},
+		{templateImageService("my-web"), "dev-project", "RegionOne", regions, "openstack-heat-my-web-template-dev-project-project-RegionOne-region-apb"},
+	}
+	for _, c := range cases {
+		name := imageName(c.service, c.project, c.region)
+		if name != c.name {
+			t.Errorf("expected %v, got %v", c.name, name)
+			continue
+		}
+
+		r := OpenstackAdapter{Regions: c.regions}
+		service, project, region, err := r.parseImageName(name)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if service == templateService {
+			// Template specs carry the template in front of the project
+			var template string
+			template, project, err = splitTemplateProject(project)
+			if err != nil {
+				t.Errorf("%v: %v", name, err)
+				continue
+			}
+			service = templateImageService(template)
+		}
+		if service != c.service || project != c.project || region != c.region {
+			t.Errorf("%v: expected %v %v %v, got %v %v %v", name, c.service, c.project, c.region, service, project, region)
+		}
+	}
+}
+
+func TestParseImageNameRejectsOtherNames(t *testing.T) {
+	r := OpenstackAdapter{}
+	for _, name := range []string{"openstack-unknown-demo-project-apb", "openstack-vm-demo-apb", "postgresql-apb", ""} {
+		if service, project, region, err := r.parseImageName(name); err == nil {
+			t.Errorf("expected %v to be rejected, got %v %v %v", name, service, project, region)
+		}
+	}
+}
+
+func TestLoadSpecSkipsServicesMissingFromCatalog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			w.Header().Set("X-Subject-Token", "token")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token":{"expires_at":%q,"project":{"id":"p1","name":"demo"},"catalog":[
+				{"type":"compute","endpoints":[{"interface":"public","region":"RegionOne","url":"%v/compute"}]},
+				{"type":"orchestration","endpoints":[{"interface":"public","region":"RegionOne","url":"%v/heat"}]},
+				{"type":"database","endpoints":[{"interface":"internal","region":"RegionOne","url":"%v/database"}]}]}}`,
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339), "http://"+r.Host, "http://"+r.Host, "http://"+r.Host)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	r := newTestAdapter(t, server)
+
+	for _, service := range []string{"database", "k8s-cluster", "share"} {
+		spec, err := r.loadSpec(imageName(service, "demo", ""))
+		if err != nil || spec != nil {
+			t.Errorf("expected no %v spec without its public endpoint, got %v, %v", service, spec, err)
+		}
+	}
+	spec, err := r.loadSpec(imageName("vm", "demo", ""))
+	if err != nil || spec == nil {
+		t.Errorf("expected a vm spec, got %v, %v", spec, err)
+	}
+
+	r.Regions = []string{"RegionTwo"}
+	spec, err = r.loadSpec(imageName("vm", "demo", "RegionTwo"))
+	if err != nil || spec != nil {
+		t.Errorf("expected no vm spec in a region without compute, got %v, %v", spec, err)
+	}
+}
@@ -0,0 +1,219 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultDomainID = "default"
+
+type authDomain struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type authUser struct {
+	Name     string      `json:"name,omitempty"`
+	Domain   *authDomain `json:"domain,omitempty"`
+	Password string      `json:"password,omitempty"`
+}
+
+type authPassword struct {
+	User authUser `json:"user"`
+}
+
+type authApplicationCredential struct {
+	ID     string    `json:"id,omitempty"`
+	Name   string    `json:"name,omitempty"`
+	Secret string    `json:"secret"`
+	User   *authUser `json:"user,omitempty"`
+}
+
+type authToken struct {
+	ID string `json:"id"`
+}
+
+type authIdentity struct {
+	Methods               []string                   `json:"methods"`
+	Password              *authPassword              `json:"password,omitempty"`
+	ApplicationCredential *authApplicationCredential `json:"application_credential,omitempty"`
+	Token                 *authToken                 `json:"token,omitempty"`
+}
+
+type authProject struct {
+	Name   string     `json:"name"`
+	Domain authDomain `json:"domain"`
+}
+
+type authScope struct {
+	Project *authProject `json:"project,omitempty"`
+}
+
+type authBody struct {
+	Identity authIdentity `json:"identity"`
+	Scope    *authScope   `json:"scope,omitempty"`
+}
+
+type authRequest struct {
+	Auth authBody `json:"auth"`
+}
+
+// userDomain - the domain of the registry user, the default domain if unset.
+// An id takes precedence over a name.
+func (r OpenstackAdapter) userDomain() authDomain {
+	return domain(r.UserDomainID, r.UserDomainName)
+}
+
+// projectDomain - the domain of the scoped projects, the default domain if
+// unset. An id takes precedence over a name.
+func (r OpenstackAdapter) projectDomain() authDomain {
+	return domain(r.ProjectDomainID, r.ProjectDomainName)
+}
+
+// projectDomainLabel - a human readable name of the project domain
+func (r OpenstackAdapter) projectDomainLabel() string {
+	d := r.projectDomain()
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.ID
+}
+
+// resolveProjectDomainID - the id of the project domain, looked up by name
+// when only the name is configured since projects only carry their domain
+// id. Empty when neither is configured.
+func (r OpenstackAdapter) resolveProjectDomainID() (string, error) {
+	if r.ProjectDomainID != "" || r.ProjectDomainName == "" {
+		return r.ProjectDomainID, nil
+	}
+
+	_, unscoped, err := r.getScopedToken("")
+	if err != nil {
+		return "", err
+	}
+	if unscoped.User.Domain.Name == r.ProjectDomainName && unscoped.User.Domain.ID != "" {
+		return unscoped.User.Domain.ID, nil
+	}
+
+	// Domains the user has a role on are visible to any user, looking a
+	// domain up by name usually needs a reader or admin role.
+	paths := []string{"/auth/domains", "/domains?name=" + url.QueryEscape(r.ProjectDomainName)}
+	for _, path := range paths {
+		domains := struct {
+			Domains []authDomain `json:"domains"`
+		}{}
+		if err := r.getObject("", identityURL(r.Config.URL.String(), path), &domains); err != nil {
+			log.Infof("Could not list domains at %v: %s", path, err)
+			continue
+		}
+		for _, d := range domains.Domains {
+			if d.Name == r.ProjectDomainName {
+				return d.ID, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("could not resolve project domain %v to an id", r.ProjectDomainName)
+}
+
+func domain(id string, name string) authDomain {
+	switch {
+	case id != "":
+		return authDomain{ID: id}
+	case name != "":
+		return authDomain{Name: name}
+	default:
+		return authDomain{ID: defaultDomainID}
+	}
+}
+
+// usesApplicationCredential - whether the registry authenticates with a
+// Keystone application credential instead of a password.
+func (r OpenstackAdapter) usesApplicationCredential() bool {
+	return r.applicationCredentialID() != "" || r.ApplicationCredentialName != ""
+}
+
+// authBytes - build a token request, scoped to the project if one is given.
+// Application credentials are bound to a single project and are never
+// explicitly scoped.
+func (r OpenstackAdapter) authBytes(project string) ([]byte, error) {
+	var request authRequest
+	if r.usesApplicationCredential() {
+		credential := &authApplicationCredential{
+			ID:     r.applicationCredentialID(),
+			Secret: r.applicationCredentialSecret(),
+		}
+		if r.applicationCredentialID() == "" {
+			if r.user() == "" {
+				return nil, fmt.Errorf("application_credential_name %v requires a user", r.ApplicationCredentialName)
+			}
+			userDomain := r.userDomain()
+			credential.Name = r.ApplicationCredentialName
+			credential.User = &authUser{Name: r.user(), Domain: &userDomain}
+		}
+		request.Auth.Identity = authIdentity{
+			Methods:               []string{"application_credential"},
+			ApplicationCredential: credential,
+		}
+		return json.Marshal(request)
+	}
+
+	userDomain := r.userDomain()
+	request.Auth.Identity = authIdentity{
+		Methods: []string{"password"},
+		Password: &authPassword{
+			User: authUser{
+				Name:     r.user(),
+				Domain:   &userDomain,
+				Password: r.pass(),
+			},
+		},
+	}
+	if project != "" {
+		request.Auth.Scope = &authScope{
+			Project: &authProject{
+				Name:   project,
+				Domain: r.projectDomain(),
+			},
+		}
+	}
+	return json.Marshal(request)
+}
+
+// tokenAuthBytes - build a request exchanging a token for one scoped to the
+// project.
+func (r OpenstackAdapter) tokenAuthBytes(token string, project string) ([]byte, error) {
+	request := authRequest{
+		Auth: authBody{
+			Identity: authIdentity{
+				Methods: []string{"token"},
+				Token:   &authToken{ID: token},
+			},
+			Scope: &authScope{
+				Project: &authProject{
+					Name:   project,
+					Domain: r.projectDomain(),
+				},
+			},
+		},
+	}
+	return json.Marshal(request)
+}
@@ -0,0 +1,158 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// authServer - an identity API recording the token requests it is sent,
+// whose tokens are scoped to the demo project
+func authServer(t *testing.T, requests *[]authRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/identity/v3/auth/tokens" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var request authRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("could not parse token request %s: %v", body, err)
+		}
+		*requests = append(*requests, request)
+		writeToken(w, "token", time.Hour)
+	}))
+}
+
+func TestPasswordTokenRequest(t *testing.T) {
+	var requests []authRequest
+	server := authServer(t, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.UserDomainName = "users"
+	r.ProjectDomainID = "d1"
+
+	if _, _, err := r.getScopedToken("demo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected a token request, got %v", len(requests))
+	}
+	auth := requests[0].Auth
+	if len(auth.Identity.Methods) != 1 || auth.Identity.Methods[0] != "password" || auth.Identity.Password == nil {
+		t.Fatalf("expected the password method, got %+v", auth.Identity)
+	}
+	user := auth.Identity.Password.User
+	if user.Name != "user" || user.Password != "secret" || user.Domain == nil || user.Domain.Name != "users" {
+		t.Errorf("expected the user of the users domain, got %+v", user)
+	}
+	if auth.Scope == nil || auth.Scope.Project == nil || auth.Scope.Project.Name != "demo" || auth.Scope.Project.Domain.ID != "d1" {
+		t.Errorf("expected a scope to the demo project of d1, got %+v", auth.Scope)
+	}
+}
+
+func TestApplicationCredentialByID(t *testing.T) {
+	var requests []authRequest
+	server := authServer(t, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.ApplicationCredentialID = "ac1"
+	r.ApplicationCredentialSecret = "acsecret"
+
+	if _, _, err := r.getScopedToken("demo"); err != nil {
+		t.Fatal(err)
+	}
+	auth := requests[0].Auth
+	if len(auth.Identity.Methods) != 1 || auth.Identity.Methods[0] != "application_credential" || auth.Identity.Password != nil {
+		t.Fatalf("expected only the application_credential method, got %+v", auth.Identity)
+	}
+	credential := auth.Identity.ApplicationCredential
+	if credential == nil || credential.ID != "ac1" || credential.Secret != "acsecret" || credential.Name != "" || credential.User != nil {
+		t.Errorf("expected the credential by id alone, got %+v", credential)
+	}
+	if auth.Scope != nil {
+		t.Errorf("expected no explicit scope, got %+v", auth.Scope)
+	}
+}
+
+func TestApplicationCredentialByName(t *testing.T) {
+	var requests []authRequest
+	server := authServer(t, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.ApplicationCredentialName = "broker"
+	r.ApplicationCredentialSecret = "acsecret"
+	r.UserDomainID = "u1"
+
+	if _, _, err := r.getScopedToken("demo"); err != nil {
+		t.Fatal(err)
+	}
+	auth := requests[0].Auth
+	credential := auth.Identity.ApplicationCredential
+	if credential == nil || credential.ID != "" || credential.Name != "broker" || credential.Secret != "acsecret" {
+		t.Fatalf("expected the credential by name, got %+v", credential)
+	}
+	if credential.User == nil || credential.User.Name != "user" || credential.User.Password != "" || credential.User.Domain == nil || credential.User.Domain.ID != "u1" {
+		t.Errorf("expected the owning user and domain without a password, got %+v", credential.User)
+	}
+	if auth.Scope != nil {
+		t.Errorf("expected no explicit scope, got %+v", auth.Scope)
+	}
+}
+
+func TestApplicationCredentialNameRequiresUser(t *testing.T) {
+	var requests []authRequest
+	server := authServer(t, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.Config.User = ""
+	r.ApplicationCredentialName = "broker"
+	r.ApplicationCredentialSecret = "acsecret"
+
+	if _, _, err := r.getScopedToken("demo"); err == nil {
+		t.Error("expected a credential name without a user to fail")
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no token request, got %v", len(requests))
+	}
+}
+
+func TestApplicationCredentialBoundProject(t *testing.T) {
+	var requests []authRequest
+	server := authServer(t, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.ApplicationCredentialID = "ac1"
+	r.ApplicationCredentialSecret = "acsecret"
+
+	_, _, err := r.getScopedToken("other")
+	if err == nil || !strings.Contains(err.Error(), "bound to project demo, not other") {
+		t.Errorf("expected a bound project mismatch, got %v", err)
+	}
+	if _, _, err := r.getScopedToken("demo"); err != nil {
+		t.Errorf("expected the bound project to be scoped, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultInterface = "public"
+
+// Endpoint - a single endpoint of a service in the Keystone catalog
+type Endpoint struct {
+	Interface string `json:"interface"`
+	Region    string `json:"region"`
+	RegionID  string `json:"region_id"`
+	URL       string `json:"url"`
+}
+
+// CatalogEntry - a service in the Keystone catalog
+type CatalogEntry struct {
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// Catalog - the service catalog returned with a scoped token
+type Catalog []CatalogEntry
+
+// EndpointURL - resolve the url of a service by type, interface and region.
+// The service type may be a comma separated list of alternative types. An
+// empty interface means public and an empty region matches any region.
+func (c Catalog) EndpointURL(serviceType string, iface string, region string) (string, error) {
+	if iface == "" {
+		iface = defaultInterface
+	}
+	for _, alternative := range strings.Split(serviceType, ",") {
+		if url, ok := c.endpointURL(alternative, iface, region); ok {
+			return url, nil
+		}
+	}
+	if region == "" {
+		return "", fmt.Errorf("no %v endpoint found in catalog for interface %v", serviceType, iface)
+	}
+	return "", fmt.Errorf("no %v endpoint found in catalog for interface %v in region %v", serviceType, iface, region)
+}
+
+func (c Catalog) endpointURL(serviceType string, iface string, region string) (string, bool) {
+	for _, entry := range c {
+		if entry.Type != serviceType {
+			continue
+		}
+		for _, endpoint := range entry.Endpoints {
+			if endpoint.Interface != iface {
+				continue
+			}
+			if region != "" && endpoint.Region != region && endpoint.RegionID != region {
+				continue
+			}
+			return strings.TrimSuffix(endpoint.URL, "/"), true
+		}
+	}
+	return "", false
+}
+
+// identityURL - build a Keystone v3 url from an identity endpoint which may
+// or may not already carry the version suffix.
+func identityURL(endpoint string, path string) string {
+	endpoint = strings.TrimSuffix(strings.TrimSuffix(endpoint, "/"), "/v3")
+	return fmt.Sprintf("%v/v3%v", endpoint, path)
+}
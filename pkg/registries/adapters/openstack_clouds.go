@@ -0,0 +1,208 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// envCloud - the cloud name that is configured from OS_* environment
+// variables alone.
+const envCloud = "envvars"
+
+// CloudAuth - the auth section of a clouds.yaml entry
+type CloudAuth struct {
+	AuthURL                     string `yaml:"auth_url"`
+	Username                    string `yaml:"username"`
+	Password                    string `yaml:"password"`
+	ProjectName                 string `yaml:"project_name"`
+	DomainName                  string `yaml:"domain_name"`
+	DomainID                    string `yaml:"domain_id"`
+	UserDomainName              string `yaml:"user_domain_name"`
+	UserDomainID                string `yaml:"user_domain_id"`
+	ProjectDomainName           string `yaml:"project_domain_name"`
+	ProjectDomainID             string `yaml:"project_domain_id"`
+	ApplicationCredentialID     string `yaml:"application_credential_id"`
+	ApplicationCredentialName   string `yaml:"application_credential_name"`
+	ApplicationCredentialSecret string `yaml:"application_credential_secret"`
+	IdentityProvider            string `yaml:"identity_provider"`
+	Protocol                    string `yaml:"protocol"`
+	DiscoveryEndpoint           string `yaml:"discovery_endpoint"`
+	AccessTokenEndpoint         string `yaml:"access_token_endpoint"`
+	ClientID                    string `yaml:"client_id"`
+	ClientSecret                string `yaml:"client_secret"`
+	OpenIDScope                 string `yaml:"openid_scope"`
+	AccessToken                 string `yaml:"access_token"`
+}
+
+// CloudConfig - a clouds.yaml entry with OS_* environment overrides applied
+type CloudConfig struct {
+	AuthType   string    `yaml:"auth_type"`
+	Auth       CloudAuth `yaml:"auth"`
+	RegionName string    `yaml:"region_name"`
+	Interface  string    `yaml:"interface"`
+	CACert     string    `yaml:"cacert"`
+	Cert       string    `yaml:"cert"`
+	Key        string    `yaml:"key"`
+	Verify     *bool     `yaml:"verify"`
+}
+
+type cloudsFile struct {
+	Clouds map[string]CloudConfig `yaml:"clouds"`
+}
+
+// Insecure - whether the cloud disables TLS verification
+func (c CloudConfig) Insecure() bool {
+	return c.Verify != nil && !*c.Verify
+}
+
+// LoadCloudConfig - load the named cloud from a clouds.yaml, then apply any
+// OS_* environment variables on top. An empty name loads the environment
+// alone. When cloudsFile is empty the standard locations are searched in
+// order: $OS_CLIENT_CONFIG_FILE, ./clouds.yaml,
+// ~/.config/openstack/clouds.yaml and /etc/openstack/clouds.yaml.
+func LoadCloudConfig(name string, cloudsFile string) (CloudConfig, error) {
+	var cloud CloudConfig
+	source := "the environment"
+
+	if name != "" {
+		path := cloudsFile
+		if path == "" {
+			path = findCloudsFile()
+		}
+
+		if path != "" {
+			clouds, err := readCloudsFile(path)
+			if err != nil {
+				return cloud, err
+			}
+			entry, ok := clouds.Clouds[name]
+			if !ok && name != envCloud {
+				return cloud, fmt.Errorf("cloud %v not found in %v", name, path)
+			}
+			cloud = entry
+			source = path
+		} else if name != envCloud {
+			return cloud, fmt.Errorf("cloud %v requested but no clouds.yaml was found", name)
+		}
+	}
+
+	cloud.applyEnvironment()
+
+	// domain_name and domain_id apply to both the user and project
+	if cloud.Auth.UserDomainName == "" && cloud.Auth.UserDomainID == "" {
+		cloud.Auth.UserDomainName = cloud.Auth.DomainName
+		cloud.Auth.UserDomainID = cloud.Auth.DomainID
+	}
+	if cloud.Auth.ProjectDomainName == "" && cloud.Auth.ProjectDomainID == "" {
+		cloud.Auth.ProjectDomainName = cloud.Auth.DomainName
+		cloud.Auth.ProjectDomainID = cloud.Auth.DomainID
+	}
+
+	if name != "" {
+		log.Infof("Loaded cloud %v from %v", name, source)
+	}
+	return cloud, nil
+}
+
+func findCloudsFile() string {
+	candidates := []string{os.Getenv("OS_CLIENT_CONFIG_FILE"), "clouds.yaml"}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "openstack", "clouds.yaml"))
+	}
+	candidates = append(candidates, "/etc/openstack/clouds.yaml")
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func readCloudsFile(path string) (cloudsFile, error) {
+	var clouds cloudsFile
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return clouds, fmt.Errorf("unable to read clouds file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &clouds); err != nil {
+		return clouds, fmt.Errorf("unable to parse clouds file %v: %v", path, err)
+	}
+	return clouds, nil
+}
+
+// applyEnvironment - override the cloud with any OS_* environment variables
+func (c *CloudConfig) applyEnvironment() {
+	overrides := map[string]*string{
+		"OS_AUTH_URL":                      &c.Auth.AuthURL,
+		"OS_USERNAME":                      &c.Auth.Username,
+		"OS_PASSWORD":                      &c.Auth.Password,
+		"OS_PROJECT_NAME":                  &c.Auth.ProjectName,
+		"OS_APPLICATION_CREDENTIAL_ID":     &c.Auth.ApplicationCredentialID,
+		"OS_APPLICATION_CREDENTIAL_NAME":   &c.Auth.ApplicationCredentialName,
+		"OS_APPLICATION_CREDENTIAL_SECRET": &c.Auth.ApplicationCredentialSecret,
+		"OS_IDENTITY_PROVIDER":             &c.Auth.IdentityProvider,
+		"OS_PROTOCOL":                      &c.Auth.Protocol,
+		"OS_DISCOVERY_ENDPOINT":            &c.Auth.DiscoveryEndpoint,
+		"OS_ACCESS_TOKEN_ENDPOINT":         &c.Auth.AccessTokenEndpoint,
+		"OS_CLIENT_ID":                     &c.Auth.ClientID,
+		"OS_CLIENT_SECRET":                 &c.Auth.ClientSecret,
+		"OS_OPENID_SCOPE":                  &c.Auth.OpenIDScope,
+		"OS_ACCESS_TOKEN":                  &c.Auth.AccessToken,
+		"OS_AUTH_TYPE":                     &c.AuthType,
+		"OS_REGION_NAME":                   &c.RegionName,
+		"OS_INTERFACE":                     &c.Interface,
+		"OS_CACERT":                        &c.CACert,
+		"OS_CERT":                          &c.Cert,
+		"OS_KEY":                           &c.Key,
+	}
+	for env, field := range overrides {
+		if value, ok := os.LookupEnv(env); ok && value != "" {
+			*field = value
+		}
+	}
+
+	// A domain is given by its name or its id, setting either replaces both
+	// so a name from the environment is not shadowed by an id in the file
+	domains := []struct {
+		nameEnv string
+		idEnv   string
+		name    *string
+		id      *string
+	}{
+		{"OS_DOMAIN_NAME", "OS_DOMAIN_ID", &c.Auth.DomainName, &c.Auth.DomainID},
+		{"OS_USER_DOMAIN_NAME", "OS_USER_DOMAIN_ID", &c.Auth.UserDomainName, &c.Auth.UserDomainID},
+		{"OS_PROJECT_DOMAIN_NAME", "OS_PROJECT_DOMAIN_ID", &c.Auth.ProjectDomainName, &c.Auth.ProjectDomainID},
+	}
+	for _, d := range domains {
+		name, id := os.Getenv(d.nameEnv), os.Getenv(d.idEnv)
+		if name != "" || id != "" {
+			*d.name = name
+			*d.id = id
+		}
+	}
+}
@@ -0,0 +1,91 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setenv - set environment variables for the duration of a test
+func setenv(t *testing.T, env map[string]string) func() {
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for key := range env {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestLoadCloudConfigEnvironmentWithoutCloud(t *testing.T) {
+	defer setenv(t, map[string]string{
+		"OS_AUTH_URL":  "https://keystone.example.com/v3",
+		"OS_INTERFACE": "internal",
+		"OS_DOMAIN_ID": "d1",
+	})()
+
+	cloud, err := LoadCloudConfig("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloud.Auth.AuthURL != "https://keystone.example.com/v3" || cloud.Interface != "internal" {
+		t.Errorf("expected the environment to apply without a cloud, got %+v", cloud)
+	}
+	if cloud.Auth.UserDomainID != "d1" || cloud.Auth.ProjectDomainID != "d1" {
+		t.Errorf("expected OS_DOMAIN_ID to apply to the user and project, got %+v", cloud.Auth)
+	}
+}
+
+func TestLoadCloudConfigDomainPairs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openstack-clouds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "clouds.yaml")
+	ioutil.WriteFile(path, []byte(`
+clouds:
+  devstack:
+    auth:
+      auth_url: https://keystone.example.com/v3
+      user_domain_id: file-user-domain
+      project_domain_id: file-project-domain
+    interface: public
+`), 0600)
+	defer setenv(t, map[string]string{"OS_USER_DOMAIN_NAME": "Users"})()
+
+	cloud, err := LoadCloudConfig("devstack", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloud.Auth.UserDomainName != "Users" || cloud.Auth.UserDomainID != "" {
+		t.Errorf("expected the user domain name to replace the id of the file, got %+v", cloud.Auth)
+	}
+	if cloud.Auth.ProjectDomainID != "file-project-domain" {
+		t.Errorf("expected the project domain of the file, got %+v", cloud.Auth)
+	}
+
+	if _, err := LoadCloudConfig("missing", path); err == nil {
+		t.Error("expected an unknown cloud to fail")
+	}
+}
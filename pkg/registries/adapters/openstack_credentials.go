@@ -0,0 +1,204 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/automationbroker/bundle-lib/clients"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Credentials - registry credentials read from a secret or file. Empty
+// values fall back to the registry configuration.
+type Credentials struct {
+	Username                    string `yaml:"username"`
+	Password                    string `yaml:"password"`
+	ApplicationCredentialID     string `yaml:"application_credential_id"`
+	ApplicationCredentialSecret string `yaml:"application_credential_secret"`
+	// ClientSecret, AccessToken - the OIDC client secret and access token of
+	// a federated identity
+	ClientSecret string `yaml:"client_secret"`
+	AccessToken  string `yaml:"access_token"`
+}
+
+func (c Credentials) empty() bool {
+	return c.Password == "" && c.ApplicationCredentialSecret == "" && c.ClientSecret == "" && c.AccessToken == ""
+}
+
+// CredentialSource - a Kubernetes Secret or mounted file the registry
+// credentials are re-read from on every refresh, so rotated credentials are
+// picked up without a restart. Safe for concurrent use.
+type CredentialSource struct {
+	AuthType  string
+	AuthName  string
+	Namespace string
+
+	mutex   sync.RWMutex
+	current Credentials
+}
+
+// NewCredentialSource - create the credential source for the registry
+// auth_type and auth_name. Returns nil when credentials are inline.
+func NewCredentialSource(authType string, authName string, namespace string) (*CredentialSource, error) {
+	switch authType {
+	case "", "config":
+		return nil, nil
+	case "secret", "file":
+		if authName == "" {
+			return nil, fmt.Errorf("auth_type %v requires an auth_name", authType)
+		}
+		return &CredentialSource{AuthType: authType, AuthName: authName, Namespace: namespace}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized registry auth_type: %v", authType)
+	}
+}
+
+// Refresh - re-read the credentials from the source
+func (s *CredentialSource) Refresh() error {
+	if s == nil {
+		return nil
+	}
+
+	var credentials Credentials
+	var err error
+	switch s.AuthType {
+	case "secret":
+		credentials, err = readCredentialSecret(s.AuthName, s.Namespace)
+	case "file":
+		credentials, err = readCredentialFile(s.AuthName)
+	}
+	if err != nil {
+		return err
+	}
+	if credentials.empty() {
+		return fmt.Errorf("%v %v does not contain a password, application_credential_secret, client_secret or access_token", s.AuthType, s.AuthName)
+	}
+
+	s.mutex.Lock()
+	s.current = credentials
+	s.mutex.Unlock()
+	log.Debugf("Refreshed registry credentials from %v %v", s.AuthType, s.AuthName)
+	return nil
+}
+
+// Get - the credentials read by the last successful Refresh
+func (s *CredentialSource) Get() Credentials {
+	if s == nil {
+		return Credentials{}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.current
+}
+
+func readCredentialSecret(secretName string, namespace string) (Credentials, error) {
+	data, err := clients.GetSecretData(secretName, namespace)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("unable to read registry credentials from secret %v: %v", secretName, err)
+	}
+	return Credentials{
+		Username:                    strings.TrimSpace(string(data["username"])),
+		Password:                    strings.TrimSpace(string(data["password"])),
+		ApplicationCredentialID:     strings.TrimSpace(string(data["application_credential_id"])),
+		ApplicationCredentialSecret: strings.TrimSpace(string(data["application_credential_secret"])),
+		ClientSecret:                strings.TrimSpace(string(data["client_secret"])),
+		AccessToken:                 strings.TrimSpace(string(data["access_token"])),
+	}, nil
+}
+
+func readCredentialFile(fileName string) (Credentials, error) {
+	var credentials Credentials
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return credentials, fmt.Errorf("unable to read registry credentials from file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &credentials); err != nil {
+		return credentials, fmt.Errorf("unable to parse registry credentials file %v: %v", fileName, err)
+	}
+	return credentials, nil
+}
+
+// credentialsSecret - the broker secret the runner reads its credentials
+// from. Empty when the runner is given credential parameters. The secret of
+// auth_type secret is not used in its place, as only the broker secrets
+// configuration gets a secret mounted into the runner sandbox.
+func (r OpenstackAdapter) credentialsSecret() string {
+	return r.CredentialsSecret
+}
+
+// catalogSecret - a secret as the default of a plan parameter. Credentials
+// read from a secret or file are kept out of the catalog.
+func (r OpenstackAdapter) catalogSecret(secret string) string {
+	if r.Credentials != nil {
+		return ""
+	}
+	return secret
+}
+
+// user - the registry user name
+func (r OpenstackAdapter) user() string {
+	if username := r.Credentials.Get().Username; username != "" {
+		return username
+	}
+	return r.Config.User
+}
+
+// pass - the registry user password
+func (r OpenstackAdapter) pass() string {
+	if password := r.Credentials.Get().Password; password != "" {
+		return password
+	}
+	return r.Config.Pass
+}
+
+// applicationCredentialID - the id of the registry application credential
+func (r OpenstackAdapter) applicationCredentialID() string {
+	if id := r.Credentials.Get().ApplicationCredentialID; id != "" {
+		return id
+	}
+	return r.ApplicationCredentialID
+}
+
+// applicationCredentialSecret - the secret of the registry application credential
+func (r OpenstackAdapter) applicationCredentialSecret() string {
+	if secret := r.Credentials.Get().ApplicationCredentialSecret; secret != "" {
+		return secret
+	}
+	return r.ApplicationCredentialSecret
+}
+
+// clientSecret - the OIDC client secret of the registry identity
+func (r OpenstackAdapter) clientSecret() string {
+	if secret := r.Credentials.Get().ClientSecret; secret != "" {
+		return secret
+	}
+	return r.OIDC.ClientSecret
+}
+
+// accessToken - the OIDC access token of the registry identity
+func (r OpenstackAdapter) accessToken() string {
+	if token := r.Credentials.Get().AccessToken; token != "" {
+		return token
+	}
+	return r.OIDC.AccessToken
+}
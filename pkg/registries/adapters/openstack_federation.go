@@ -0,0 +1,273 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported values of the registry auth_method
+const (
+	AuthMethodPassword              = "password"
+	AuthMethodOIDCPassword          = "v3oidcpassword"
+	AuthMethodOIDCClientCredentials = "v3oidcclientcredentials"
+	AuthMethodOIDCAccessToken       = "v3oidcaccesstoken"
+)
+
+const defaultOIDCScope = "openid"
+
+// OIDCConfig - how an access token is obtained from an OpenID Connect
+// identity provider and exchanged for a Keystone token. The token endpoint
+// is AccessTokenEndpoint, or discovered from DiscoveryEndpoint.
+type OIDCConfig struct {
+	IdentityProvider    string
+	Protocol            string
+	DiscoveryEndpoint   string
+	AccessTokenEndpoint string
+	ClientID            string
+	ClientSecret        string
+	Scope               string
+	AccessToken         string
+}
+
+// discoveredEndpoints - the token endpoints read from discovery documents,
+// by document. An identity provider's token endpoint does not change while
+// the broker runs.
+var discoveredEndpoints = struct {
+	sync.Mutex
+	endpoints map[string]string
+}{endpoints: map[string]string{}}
+
+type oidcDiscovery struct {
+	TokenEndpoint string `json:"token_endpoint"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// ValidateAuthMethod - make sure the auth method is known and has what it
+// needs to authenticate. With a credential source the access token may be
+// read from it instead.
+func ValidateAuthMethod(method string, oidc OIDCConfig, credentials *CredentialSource) error {
+	switch method {
+	case "", AuthMethodPassword, "v3password", "v3applicationcredential":
+		return nil
+	case AuthMethodOIDCPassword, AuthMethodOIDCClientCredentials, AuthMethodOIDCAccessToken:
+	default:
+		return fmt.Errorf("unrecognized auth_method: %v", method)
+	}
+
+	if oidc.IdentityProvider == "" || oidc.Protocol == "" {
+		return fmt.Errorf("auth_method %v requires an identity_provider and protocol", method)
+	}
+	if method == AuthMethodOIDCAccessToken {
+		if oidc.AccessToken == "" && credentials == nil {
+			return fmt.Errorf("auth_method %v requires an access_token", method)
+		}
+		return nil
+	}
+	if oidc.DiscoveryEndpoint == "" && oidc.AccessTokenEndpoint == "" {
+		return fmt.Errorf("auth_method %v requires a discovery_endpoint or access_token_endpoint", method)
+	}
+	if oidc.ClientID == "" {
+		return fmt.Errorf("auth_method %v requires a client_id", method)
+	}
+	return nil
+}
+
+// usesFederation - whether the registry identity is a federated OIDC identity
+func (r OpenstackAdapter) usesFederation() bool {
+	switch r.AuthMethod {
+	case AuthMethodOIDCPassword, AuthMethodOIDCClientCredentials, AuthMethodOIDCAccessToken:
+		return true
+	}
+	return false
+}
+
+// issueFederatedToken - exchange an OIDC access token for an unscoped
+// Keystone token.
+func (r OpenstackAdapter) issueFederatedToken() (string, Token, error) {
+	accessToken, err := r.oidcAccessToken()
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	authUrl := identityURL(r.Config.URL.String(), fmt.Sprintf("/OS-FEDERATION/identity_providers/%v/protocols/%v/auth",
+		url.PathEscape(r.OIDC.IdentityProvider), url.PathEscape(r.OIDC.Protocol)))
+	req, err := http.NewRequest("POST", authUrl, nil)
+	if err != nil {
+		return "", Token{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	body, header, err := r.doRequest(req)
+	if err != nil {
+		return "", Token{}, fmt.Errorf("federated authentication with %v failed: %v", r.OIDC.IdentityProvider, err)
+	}
+
+	objectResponse := TokenResponse{}
+	if err := json.Unmarshal(body, &objectResponse); err != nil {
+		return "", Token{}, err
+	}
+	return header.Get("X-Subject-Token"), objectResponse.Token, nil
+}
+
+// oidcAccessToken - get an access token from the identity provider
+func (r OpenstackAdapter) oidcAccessToken() (string, error) {
+	form := url.Values{}
+	switch r.AuthMethod {
+	case AuthMethodOIDCAccessToken:
+		return r.accessToken(), nil
+	case AuthMethodOIDCPassword:
+		form.Set("grant_type", "password")
+		form.Set("username", r.user())
+		form.Set("password", r.pass())
+	case AuthMethodOIDCClientCredentials:
+		form.Set("grant_type", "client_credentials")
+	}
+	scope := r.OIDC.Scope
+	if scope == "" {
+		scope = defaultOIDCScope
+	}
+	form.Set("scope", scope)
+
+	endpoint, err := r.oidcTokenEndpoint()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(r.OIDC.ClientID), url.QueryEscape(r.clientSecret()))
+
+	body, _, err := r.doRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to get an access token from %v: %v", endpoint, err)
+	}
+	tokenResponse := oidcTokenResponse{}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", err
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", errors.New("identity provider did not return an access_token")
+	}
+	return tokenResponse.AccessToken, nil
+}
+
+// oidcTokenEndpoint - the configured token endpoint, or the one advertised by
+// the discovery document of the identity provider, which is read once
+func (r OpenstackAdapter) oidcTokenEndpoint() (string, error) {
+	if r.OIDC.AccessTokenEndpoint != "" {
+		return r.OIDC.AccessTokenEndpoint, nil
+	}
+
+	discoveredEndpoints.Lock()
+	defer discoveredEndpoints.Unlock()
+	if endpoint, ok := discoveredEndpoints.endpoints[r.OIDC.DiscoveryEndpoint]; ok {
+		return endpoint, nil
+	}
+
+	req, err := http.NewRequest("GET", r.OIDC.DiscoveryEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	body, _, err := r.doRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to read discovery document %v: %v", r.OIDC.DiscoveryEndpoint, err)
+	}
+	discovery := oidcDiscovery{}
+	if err := json.Unmarshal(body, &discovery); err != nil {
+		return "", err
+	}
+	if discovery.TokenEndpoint == "" {
+		return "", fmt.Errorf("discovery document %v has no token_endpoint", r.OIDC.DiscoveryEndpoint)
+	}
+	log.Debugf("Discovered OIDC token endpoint %v", discovery.TokenEndpoint)
+	discoveredEndpoints.endpoints[r.OIDC.DiscoveryEndpoint] = discovery.TokenEndpoint
+	return discovery.TokenEndpoint, nil
+}
+
+// doRequest - perform a request with the adapter client and return the body
+// of a successful response.
+func (r OpenstackAdapter) doRequest(req *http.Request) ([]byte, http.Header, error) {
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		if terr := tlsVerificationError(req.URL.Host, err); terr != nil {
+			return nil, nil, terr
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, statusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Header, nil
+}
+
+// ValidateRunnerCredentials - make sure the runner can get the secrets of a
+// federated identity. Client secrets, passwords and access tokens are never
+// put in the catalog, the runner reads them from the credentials secret.
+func (r OpenstackAdapter) ValidateRunnerCredentials() error {
+	if r.usesFederation() && r.credentialsSecret() == "" {
+		return fmt.Errorf("auth_method %v requires a credentials_secret for the runner", r.AuthMethod)
+	}
+	return nil
+}
+
+// federationParameters - what the runner needs to authenticate with the
+// same federated identity, other than its secrets
+func (r OpenstackAdapter) federationParameters() []map[string]string {
+	parameters := []map[string]string{
+		{"name": "auth_method", "title": "Auth Method", "default": r.AuthMethod, "type": "string", "displaytype": ""},
+		{"name": "identity_provider", "title": "Identity Provider", "default": r.OIDC.IdentityProvider, "type": "string", "displaytype": ""},
+		{"name": "protocol", "title": "Federation Protocol", "default": r.OIDC.Protocol, "type": "string", "displaytype": ""},
+	}
+	if r.AuthMethod == AuthMethodOIDCAccessToken {
+		return parameters
+	}
+
+	endpoint, err := r.oidcTokenEndpoint()
+	if err != nil {
+		log.Warningf("Could not resolve the OIDC token endpoint: %s", err)
+	}
+	parameters = append(parameters,
+		map[string]string{"name": "access_token_endpoint", "title": "Access Token Endpoint", "default": endpoint, "type": "string", "displaytype": ""},
+		map[string]string{"name": "client_id", "title": "Client ID", "default": r.OIDC.ClientID, "type": "string", "displaytype": ""},
+	)
+	if r.AuthMethod == AuthMethodOIDCPassword {
+		parameters = append(parameters,
+			map[string]string{"name": "user", "title": "User", "default": r.user(), "type": "string", "displaytype": ""})
+	}
+	return parameters
+}
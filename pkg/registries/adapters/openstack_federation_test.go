@@ -0,0 +1,317 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// federationServer - an identity provider and a Keystone accepting its
+// access tokens, recording what they were sent
+type federationServer struct {
+	*httptest.Server
+	discoveries int
+	forms       []map[string]string
+	clients     []string
+	bearers     []string
+	scopes      []authRequest
+}
+
+func newFederationServer() *federationServer {
+	f := &federationServer{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/idp/.well-known/openid-configuration":
+			f.discoveries++
+			fmt.Fprintf(w, `{"token_endpoint":"%v/idp/token"}`, f.URL)
+		case "/idp/token":
+			r.ParseForm()
+			form := map[string]string{}
+			for key := range r.PostForm {
+				form[key] = r.PostForm.Get(key)
+			}
+			f.forms = append(f.forms, form)
+			client, secret, _ := r.BasicAuth()
+			f.clients = append(f.clients, client+":"+secret)
+			w.Write([]byte(`{"access_token":"idp-token","token_type":"Bearer"}`))
+		case "/identity/v3/OS-FEDERATION/identity_providers/myidp/protocols/openid/auth":
+			f.bearers = append(f.bearers, r.Header.Get("Authorization"))
+			writeToken(w, "unscoped", time.Hour)
+		case "/identity/v3/auth/tokens":
+			body, _ := ioutil.ReadAll(r.Body)
+			request := authRequest{}
+			json.Unmarshal(body, &request)
+			f.scopes = append(f.scopes, request)
+			writeToken(w, "scoped", time.Hour)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return f
+}
+
+func newFederatedAdapter(t *testing.T, f *federationServer, method string) OpenstackAdapter {
+	r := newTestAdapter(t, f.Server)
+	r.AuthMethod = method
+	r.OIDC = OIDCConfig{
+		IdentityProvider:  "myidp",
+		Protocol:          "openid",
+		DiscoveryEndpoint: f.URL + "/idp/.well-known/openid-configuration",
+		ClientID:          "broker",
+		ClientSecret:      "client-secret",
+		AccessToken:       "configured-token",
+	}
+	return r
+}
+
+func TestFederatedPasswordFlow(t *testing.T) {
+	f := newFederationServer()
+	defer f.Close()
+	r := newFederatedAdapter(t, f, AuthMethodOIDCPassword)
+
+	id, _, err := r.issueFederatedToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "unscoped" {
+		t.Errorf("expected the unscoped token, got %v", id)
+	}
+	if len(f.forms) != 1 {
+		t.Fatalf("expected one access token request, got %v", len(f.forms))
+	}
+	form := f.forms[0]
+	if form["grant_type"] != "password" || form["username"] != "user" || form["password"] != "secret" || form["scope"] != "openid" {
+		t.Errorf("unexpected password grant %v", form)
+	}
+	if f.clients[0] != "broker:client-secret" {
+		t.Errorf("expected the client to authenticate, got %v", f.clients[0])
+	}
+	if f.bearers[0] != "Bearer idp-token" {
+		t.Errorf("expected the access token to be exchanged, got %v", f.bearers[0])
+	}
+}
+
+func TestFederatedClientCredentialsFlow(t *testing.T) {
+	f := newFederationServer()
+	defer f.Close()
+	r := newFederatedAdapter(t, f, AuthMethodOIDCClientCredentials)
+	r.OIDC.Scope = "openid profile"
+
+	if _, _, err := r.issueFederatedToken(); err != nil {
+		t.Fatal(err)
+	}
+	form := f.forms[0]
+	if form["grant_type"] != "client_credentials" || form["scope"] != "openid profile" {
+		t.Errorf("unexpected client credentials grant %v", form)
+	}
+	if _, ok := form["password"]; ok {
+		t.Errorf("expected no password in a client credentials grant, got %v", form)
+	}
+	if f.bearers[0] != "Bearer idp-token" {
+		t.Errorf("expected the access token to be exchanged, got %v", f.bearers[0])
+	}
+}
+
+func TestFederatedAccessTokenFlow(t *testing.T) {
+	f := newFederationServer()
+	defer f.Close()
+	r := newFederatedAdapter(t, f, AuthMethodOIDCAccessToken)
+
+	if _, _, err := r.issueFederatedToken(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.forms) != 0 || f.discoveries != 0 {
+		t.Errorf("expected the identity provider not to be contacted, got %v token requests", len(f.forms))
+	}
+	if f.bearers[0] != "Bearer configured-token" {
+		t.Errorf("expected the configured access token to be exchanged, got %v", f.bearers[0])
+	}
+}
+
+func TestFederatedTokenScoping(t *testing.T) {
+	f := newFederationServer()
+	defer f.Close()
+	r := newFederatedAdapter(t, f, AuthMethodOIDCClientCredentials)
+
+	id, _, err := r.getScopedToken("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "scoped" {
+		t.Errorf("expected the scoped token, got %v", id)
+	}
+	if len(f.scopes) != 1 {
+		t.Fatalf("expected one scoping request, got %v", len(f.scopes))
+	}
+	identity := f.scopes[0].Auth.Identity
+	if len(identity.Methods) != 1 || identity.Methods[0] != "token" || identity.Token == nil || identity.Token.ID != "unscoped" {
+		t.Errorf("expected the unscoped token to be exchanged, got %+v", identity)
+	}
+	scope := f.scopes[0].Auth.Scope
+	if scope == nil || scope.Project == nil || scope.Project.Name != "demo" || scope.Project.Domain.ID != defaultDomainID {
+		t.Errorf("expected a demo project scope, got %+v", scope)
+	}
+
+	// Another project reuses the cached unscoped token
+	if _, _, err := r.getScopedToken("other"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.bearers) != 1 {
+		t.Errorf("expected a single federated authentication, got %v", len(f.bearers))
+	}
+}
+
+func TestOIDCDiscoveryIsCached(t *testing.T) {
+	f := newFederationServer()
+	defer f.Close()
+	r := newFederatedAdapter(t, f, AuthMethodOIDCClientCredentials)
+
+	for i := 0; i < 3; i++ {
+		endpoint, err := r.oidcTokenEndpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endpoint != f.URL+"/idp/token" {
+			t.Errorf("expected the discovered token endpoint, got %v", endpoint)
+		}
+	}
+	if f.discoveries != 1 {
+		t.Errorf("expected the discovery document to be read once, got %v", f.discoveries)
+	}
+
+	r.OIDC.AccessTokenEndpoint = f.URL + "/other/token"
+	if endpoint, _ := r.oidcTokenEndpoint(); endpoint != f.URL+"/other/token" {
+		t.Errorf("expected the configured token endpoint, got %v", endpoint)
+	}
+}
+
+func TestFederationParametersHoldNoSecrets(t *testing.T) {
+	f := newFederationServer()
+	defer f.Close()
+
+	for _, method := range []string{AuthMethodOIDCPassword, AuthMethodOIDCClientCredentials, AuthMethodOIDCAccessToken} {
+		r := newFederatedAdapter(t, f, method)
+		for _, parameter := range r.federationParameters() {
+			switch parameter["default"] {
+			case "secret", "client-secret", "configured-token", "idp-token":
+				t.Errorf("%v: parameter %v exposes a secret", method, parameter["name"])
+			}
+		}
+		if err := r.ValidateRunnerCredentials(); err == nil {
+			t.Errorf("%v: expected a credentials secret to be required", method)
+		}
+		// Nothing mounts the registry secret into the runner sandbox
+		r.Credentials = &CredentialSource{AuthType: "secret", AuthName: "registry-auth-secret"}
+		if err := r.ValidateRunnerCredentials(); err == nil {
+			t.Errorf("%v: expected the registry secret not to stand in for a credentials secret", method)
+		}
+		r.Credentials = nil
+		r.CredentialsSecret = "runner-credentials"
+		if err := r.ValidateRunnerCredentials(); err != nil {
+			t.Errorf("%v: %v", method, err)
+		}
+	}
+}
+
+func TestValidateAuthMethod(t *testing.T) {
+	complete := OIDCConfig{IdentityProvider: "myidp", Protocol: "openid", DiscoveryEndpoint: "https://idp/.well-known", ClientID: "broker", AccessToken: "token"}
+	valid := map[string]OIDCConfig{
+		"":                              {},
+		AuthMethodPassword:              {},
+		AuthMethodOIDCPassword:          complete,
+		AuthMethodOIDCClientCredentials: complete,
+		AuthMethodOIDCAccessToken:       {IdentityProvider: "myidp", Protocol: "openid", AccessToken: "token"},
+	}
+	for method, oidc := range valid {
+		if err := ValidateAuthMethod(method, oidc, nil); err != nil {
+			t.Errorf("%q: %v", method, err)
+		}
+	}
+
+	invalid := map[string]OIDCConfig{
+		"v3kerberos":                    complete,
+		AuthMethodOIDCPassword:          {IdentityProvider: "myidp", Protocol: "openid", ClientID: "broker"},
+		AuthMethodOIDCClientCredentials: {IdentityProvider: "myidp", DiscoveryEndpoint: "https://idp/.well-known", ClientID: "broker"},
+		AuthMethodOIDCAccessToken:       {IdentityProvider: "myidp", Protocol: "openid"},
+	}
+	for method, oidc := range invalid {
+		if err := ValidateAuthMethod(method, oidc, nil); err == nil {
+			t.Errorf("%q: expected an error for %+v", method, oidc)
+		}
+	}
+
+	// The access token may be kept in the credential source
+	source := &CredentialSource{AuthType: "secret", AuthName: "registry-auth-secret"}
+	if err := ValidateAuthMethod(AuthMethodOIDCAccessToken, OIDCConfig{IdentityProvider: "myidp", Protocol: "openid"}, source); err != nil {
+		t.Errorf("expected an access token from the credential source to be allowed, got %v", err)
+	}
+}
+
+// credentialFile - a credential source reading the yaml file written to a
+// temporary directory
+func credentialFile(t *testing.T, data string) (*CredentialSource, func()) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, "credentials.yaml")
+	if err := ioutil.WriteFile(fileName, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewCredentialSource("file", fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source, func() { os.RemoveAll(dir) }
+}
+
+func TestFederatedSecretsFromCredentialSource(t *testing.T) {
+	f := newFederationServer()
+	defer f.Close()
+
+	source, cleanup := credentialFile(t, "client_secret: rotated-secret\n")
+	defer cleanup()
+	r := newFederatedAdapter(t, f, AuthMethodOIDCClientCredentials)
+	r.OIDC.ClientSecret = ""
+	r.Credentials = source
+	if _, _, err := r.getScopedToken(""); err != nil {
+		t.Fatal(err)
+	}
+	if f.clients[0] != "broker:rotated-secret" {
+		t.Errorf("expected the client secret of the source, got %v", f.clients[0])
+	}
+
+	source, cleanup = credentialFile(t, "access_token: source-token\n")
+	defer cleanup()
+	r = newFederatedAdapter(t, f, AuthMethodOIDCAccessToken)
+	r.OIDC.AccessToken = ""
+	r.Credentials = source
+	if _, _, err := r.getScopedToken(""); err != nil {
+		t.Fatal(err)
+	}
+	if f.bearers[len(f.bearers)-1] != "Bearer source-token" {
+		t.Errorf("expected the access token of the source, got %v", f.bearers[len(f.bearers)-1])
+	}
+}
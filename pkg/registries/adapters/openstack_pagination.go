@@ -0,0 +1,179 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultMaxItems = 1000
+
+// unpagedTypes - list types whose APIs return everything at once and do not
+// take a limit
+var unpagedTypes = map[string]bool{
+	"projects":           true,
+	"availability_zones": true,
+	"storage_policies":   true,
+}
+
+type pageLink struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
+}
+
+// maxItems - the most items offered from a list
+func (r OpenstackAdapter) maxItems() int {
+	if r.MaxItems > 0 {
+		return r.MaxItems
+	}
+	return defaultMaxItems
+}
+
+// getPages - GET a list and follow its next links, handing every page to
+// page, which returns the number of items it kept. Stops once the list is
+// complete or the maximum number of items has been kept, returning true when
+// pages were left unread.
+func (r OpenstackAdapter) getPages(project string, objectType string, objectUrl string, page func([]byte) int) (bool, error) {
+	return r.getPagesWithHeader(project, objectType, objectUrl, nil, page)
+}
+
+// getPagesWithHeader - getPages with additional headers on every request
+func (r OpenstackAdapter) getPagesWithHeader(project string, objectType string, objectUrl string, header http.Header, page func([]byte) int) (bool, error) {
+	pageUrl := objectUrl
+	if r.PageSize > 0 && !unpagedTypes[objectType] {
+		var err error
+		pageUrl, err = withLimit(objectUrl, r.PageSize)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	items := 0
+	seen := make(map[string]bool)
+	for pageUrl != "" {
+		if seen[pageUrl] {
+			return false, fmt.Errorf("%v list links back to %v", objectType, pageUrl)
+		}
+		seen[pageUrl] = true
+
+		response, err := r.authenticatedRequestWithHeader(project, pageUrl, "GET", nil, header)
+		if err != nil {
+			return false, err
+		}
+		pageJson, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return false, err
+		}
+
+		items += page(pageJson)
+		pageUrl, err = nextPage(pageUrl, pageJson)
+		if err != nil {
+			return false, err
+		}
+		if items >= r.maxItems() && pageUrl != "" {
+			log.Warningf("Stopped listing %v after %v items", objectType, items)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// withLimit - the url with a page size limit, unless it already has one
+func withLimit(objectUrl string, limit int) (string, error) {
+	u, err := url.Parse(objectUrl)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	if query.Get("limit") != "" {
+		return objectUrl, nil
+	}
+	query.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// nextPage - the url of the page after the current one, empty for the last
+// page. Nova, Neutron, Cinder and Octavia link it from <type>_links, Glance
+// and Magnum from next and Keystone, Designate and Heat from links.
+func nextPage(current string, pageJson []byte) (string, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(pageJson, &fields); err != nil {
+		// Lists that are plain arrays have no links
+		return "", nil
+	}
+
+	var next string
+	for key, raw := range fields {
+		switch {
+		case key == "next":
+			json.Unmarshal(raw, &next)
+		case key == "links" || strings.HasSuffix(key, "_links"):
+			links := struct {
+				Next string `json:"next"`
+			}{}
+			if err := json.Unmarshal(raw, &links); err == nil {
+				if links.Next != "" {
+					next = links.Next
+				}
+				continue
+			}
+			var relLinks []pageLink
+			json.Unmarshal(raw, &relLinks)
+			for _, link := range relLinks {
+				if link.Rel == "next" {
+					next = link.Href
+				}
+			}
+		}
+	}
+	if next == "" {
+		return "", nil
+	}
+	return resolvePage(current, next)
+}
+
+// resolvePage - resolve a next link against the current page. Glance links
+// are relative to its API root, which may sit under a path on the endpoint.
+func resolvePage(current string, next string) (string, error) {
+	currentUrl, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	nextUrl, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	if nextUrl.IsAbs() {
+		return next, nil
+	}
+	if strings.HasPrefix(nextUrl.Path, "/") {
+		if i := strings.LastIndex(currentUrl.Path, nextUrl.Path); i > 0 {
+			nextUrl.Path = currentUrl.Path[:i] + nextUrl.Path
+		}
+	}
+	return currentUrl.ResolveReference(nextUrl).String(), nil
+}
@@ -0,0 +1,210 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextPage(t *testing.T) {
+	current := "https://cloud.example.com/compute/v2.1/flavors/detail?limit=2"
+	pages := map[string]string{
+		"type links":   `{"flavors":[],"flavors_links":[{"rel":"next","href":"https://cloud.example.com/compute/v2.1/flavors/detail?limit=2&marker=f2"}]}`,
+		"links object": `{"zones":[],"links":{"self":"x","next":"https://cloud.example.com/dns/v2/zones?marker=z2"}}`,
+		"links array":  `{"stacks":[],"links":[{"rel":"self","href":"x"},{"rel":"next","href":"https://cloud.example.com/orchestration/v1/p1/stacks?marker=s2"}]}`,
+		"next":         `{"images":[],"next":"/v2/images?marker=i2"}`,
+	}
+	expected := map[string]string{
+		"type links":   "https://cloud.example.com/compute/v2.1/flavors/detail?limit=2&marker=f2",
+		"links object": "https://cloud.example.com/dns/v2/zones?marker=z2",
+		"links array":  "https://cloud.example.com/orchestration/v1/p1/stacks?marker=s2",
+		"next":         "https://cloud.example.com/v2/images?marker=i2",
+	}
+	for name, page := range pages {
+		next, err := nextPage(current, []byte(page))
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+		} else if next != expected[name] {
+			t.Errorf("%v: expected %v, got %v", name, expected[name], next)
+		}
+	}
+
+	for _, page := range []string{`{"flavors":[],"flavors_links":[]}`, `{"links":{"self":"x","next":null}}`, `[{"name":"a"}]`} {
+		if next, err := nextPage(current, []byte(page)); err != nil || next != "" {
+			t.Errorf("expected %v to be the last page, got %q, %v", page, next, err)
+		}
+	}
+}
+
+func TestResolvePage(t *testing.T) {
+	cases := []struct {
+		current  string
+		next     string
+		expected string
+	}{
+		{"https://cloud.example.com/v2/images", "https://other.example.com/v2/images?marker=a", "https://other.example.com/v2/images?marker=a"},
+		// Glance links leave out the path of an endpoint behind a proxy
+		{"https://cloud.example.com/image/v2/images?limit=2", "/v2/images?limit=2&marker=a", "https://cloud.example.com/image/v2/images?limit=2&marker=a"},
+		{"https://cloud.example.com:9292/v2/images", "/v2/images?marker=a", "https://cloud.example.com:9292/v2/images?marker=a"},
+		{"https://cloud.example.com/dns/v2/zones", "zones?marker=a", "https://cloud.example.com/dns/v2/zones?marker=a"},
+	}
+	for _, c := range cases {
+		next, err := resolvePage(c.current, c.next)
+		if err != nil {
+			t.Errorf("%v: %v", c.next, err)
+		} else if next != c.expected {
+			t.Errorf("%v against %v: expected %v, got %v", c.next, c.current, c.expected, next)
+		}
+	}
+}
+
+func TestWithLimit(t *testing.T) {
+	u, err := withLimit("https://cloud.example.com/v2.0/networks?shared=false", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://cloud.example.com/v2.0/networks?limit=50&shared=false" {
+		t.Errorf("expected a limit to be added, got %v", u)
+	}
+	u, err = withLimit("https://cloud.example.com/v2.0/networks?limit=5", 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://cloud.example.com/v2.0/networks?limit=5" {
+		t.Errorf("expected the limit to be kept, got %v", u)
+	}
+}
+
+// pagedServer - a list of count networks in pages of two, the even ones
+// owned by p1
+func pagedServer(count int, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			writeToken(w, "token", time.Hour)
+		case "/network/v2.0/networks":
+			*requests++
+			start, _ := strconv.Atoi(r.URL.Query().Get("marker"))
+			var networks []string
+			for i := start; i < start+2 && i < count; i++ {
+				owner := "p2"
+				if i%2 == 0 {
+					owner = "p1"
+				}
+				networks = append(networks, fmt.Sprintf(`{"id":"n%v","name":"net%v","project_id":%q}`, i, i, owner))
+			}
+			links := "[]"
+			if start+2 < count {
+				links = fmt.Sprintf(`[{"rel":"next","href":"http://%v/network/v2.0/networks?limit=2&marker=%v"}]`, r.Host, start+2)
+			}
+			fmt.Fprintf(w, `{"networks":[%v],"networks_links":%v}`, strings.Join(networks, ","), links)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGetObjectsFollowsPages(t *testing.T) {
+	requests := 0
+	server := pagedServer(7, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.PageSize = 2
+
+	objects, truncated, err := r.getObjects("demo", "networks", server.URL+"/network/v2.0/networks", "p1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated {
+		t.Error("expected the complete list")
+	}
+	if requests != 4 {
+		t.Errorf("expected 4 pages to be read, got %v", requests)
+	}
+	if len(objects) != 4 || objects[0].ID != "n0" || objects[3].ID != "n6" {
+		t.Errorf("expected the networks of p1, got %v", objects)
+	}
+}
+
+func TestGetObjectsCapsKeptObjects(t *testing.T) {
+	requests := 0
+	server := pagedServer(20, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.PageSize = 2
+	r.MaxItems = 3
+
+	// Only every fourth network is kept, the cap counts those alone
+	keep := func(object Object) bool {
+		i, _ := strconv.Atoi(object.ID[1:])
+		return i%4 == 0
+	}
+	objects, truncated, err := r.getObjects("demo", "networks", server.URL+"/network/v2.0/networks", "p1", keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 3 || objects[2].ID != "n8" {
+		t.Errorf("expected the first 3 kept networks, got %v", objects)
+	}
+	if !truncated {
+		t.Error("expected the list to be reported as cut short")
+	}
+	if requests != 5 {
+		t.Errorf("expected reading to stop at the cap, got %v pages", requests)
+	}
+}
+
+func TestGetObjectsCapAtLastPage(t *testing.T) {
+	requests := 0
+	server := pagedServer(6, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.PageSize = 2
+	r.MaxItems = 3
+
+	objects, truncated, err := r.getObjects("demo", "networks", server.URL+"/network/v2.0/networks", "p1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated || len(objects) != 3 {
+		t.Errorf("expected all 3 networks without truncation, got %v, %v", objects, truncated)
+	}
+}
+
+func TestGetPagesDetectsLoops(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			writeToken(w, "token", time.Hour)
+		default:
+			fmt.Fprintf(w, `{"networks":[{"id":"n0"}],"networks_links":[{"rel":"next","href":"http://%v/network/v2.0/networks"}]}`, r.Host)
+		}
+	}))
+	defer server.Close()
+	r := newTestAdapter(t, server)
+
+	_, err := r.getPages("demo", "networks", server.URL+"/network/v2.0/networks", func([]byte) int { return 1 })
+	if err == nil {
+		t.Error("expected a page linking to itself to fail")
+	}
+}
@@ -0,0 +1,424 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/automationbroker/bundle-lib/apb"
+	log "github.com/sirupsen/logrus"
+)
+
+type quotaUsage struct {
+	Limit    int `json:"limit"`
+	InUse    int `json:"in_use"`
+	Reserved int `json:"reserved"`
+}
+
+type quotaResponse struct {
+	QuotaSet map[string]json.RawMessage `json:"quota_set"`
+}
+
+// quotaMicroversions - the microversion headers the quota paths of a catalog
+// service type need. Manila only reports usage at /detail from 2.25 on.
+var quotaMicroversions = map[string]http.Header{
+	"sharev2,shared-file-system": {"X-Openstack-Manila-Api-Version": {"2.25"}},
+}
+
+// loadParameter - build the plan parameter described by a parameterTypes
+// entry. Enums are filled from the project's resources and integers limited
+// by the project's remaining quota.
+func (r OpenstackAdapter) loadParameter(project string, region string, scope Token, pt map[string]string) apb.ParameterDescriptor {
+	return r.loadParameterFrom(project, region, scope, pt, nil)
+}
+
+// loadParameterFrom - loadParameter offering the objects a plan picked, when
+// there are any, instead of those the service lists
+func (r OpenstackAdapter) loadParameterFrom(project string, region string, scope Token, pt map[string]string, objects []Object) apb.ParameterDescriptor {
+	required, err := strconv.ParseBool(pt["required"])
+	if err != nil {
+		required = false
+	}
+
+	parameter := apb.ParameterDescriptor{
+		Name:        strings.Replace(strings.ToLower(pt["label"]), " ", "_", -1),
+		Title:       pt["label"],
+		Type:        pt["type"],
+		Description: pt["description"],
+		Pattern:     pt["pattern"],
+		DisplayType: pt["display_type"],
+		Updatable:   pt["updatable"] == "true",
+		Required:    required,
+	}
+	if parameter.Type == "" {
+		parameter.Type = "enum"
+	}
+
+	switch parameter.Type {
+	case "enum":
+		var values []string
+		ids := pt["value"] == "id"
+		if pt["values"] != "" {
+			values = strings.Split(pt["values"], ",")
+		} else {
+			var truncated bool
+			if objects == nil {
+				objects, truncated = r.listObjects(project, region, scope, pt)
+			}
+			values = objectValues(objects, ids)
+			if truncated {
+				parameter.Description = appendSentence(parameter.Description, fmt.Sprintf("Only the first %v are listed.", len(objects)))
+			}
+		}
+		parameter.Enum = values
+		if def, ok := pt["default"]; ok {
+			if def != "" {
+				parameter.Default = objectDefault(objects, def, ids)
+			}
+		} else if len(values) > 0 {
+			parameter.Default = values[0]
+		}
+		if pt["multiple"] == "true" {
+			// Enums hold a single value, several are a comma separated
+			// string limited to the same choices
+			parameter.Type = "string"
+			parameter.Enum = nil
+			parameter.Pattern = multiplePattern(values, required)
+			parameter.Description = appendSentence(parameter.Description, fmt.Sprintf("Comma separated, any of: %v", strings.Join(values, ", ")))
+		}
+	case "integer":
+		if def, err := strconv.Atoi(pt["default"]); err == nil {
+			parameter.Default = def
+		}
+		if min, err := strconv.Atoi(pt["minimum"]); err == nil {
+			minimum := apb.NilableNumber(min)
+			parameter.Minimum = &minimum
+		}
+		if max, err := strconv.Atoi(pt["maximum"]); err == nil {
+			maximum := apb.NilableNumber(max)
+			parameter.Maximum = &maximum
+		}
+		if pt["quota"] != "" {
+			url, err := r.serviceURL(scope, pt["service"], pt["quota_path"], region, false)
+			if err == nil {
+				var remaining int
+				var limited bool
+				remaining, limited, err = r.getQuotaRemaining(project, url, quotaMicroversions[pt["service"]], pt["quota"])
+				// Left for other parameters drawing on the same quota
+				if reserve, err := strconv.Atoi(pt["quota_reserve"]); err == nil {
+					remaining -= reserve
+				}
+				if limited && parameter.Minimum != nil && remaining < int(*parameter.Minimum) {
+					// A maximum below the minimum leaves no valid value,
+					// the form would not even be submittable
+					log.Warningf("The %s quota of %v is exhausted", pt["quota"], project)
+					parameter.Maximum = parameter.Minimum
+					parameter.Description = appendSentence(parameter.Description, fmt.Sprintf("The project's %v quota is exhausted, provisioning will fail until it is raised.", pt["quota"]))
+				} else if limited {
+					maximum := apb.NilableNumber(remaining)
+					parameter.Maximum = &maximum
+				}
+			}
+			if err != nil {
+				log.Warningf("Could not retrieve %s quota: %s", pt["quota"], err)
+			}
+		}
+	case "boolean":
+		if def, err := strconv.ParseBool(pt["default"]); err == nil {
+			parameter.Default = def
+		}
+	default:
+		if pt["default"] != "" {
+			parameter.Default = pt["default"]
+		}
+	}
+
+	return parameter
+}
+
+// listObjects - the objects of the "name" list of a parameterTypes entry that
+// fit in its quota. Returns true when the list was cut short.
+func (r OpenstackAdapter) listObjects(project string, region string, scope Token, pt map[string]string) ([]Object, bool) {
+	url, err := r.serviceURL(scope, pt["service"], pt["path"], region, pt["root"] == "true")
+	if err != nil {
+		log.Warningf("Could not retrieve %s: %s", pt["name"], err)
+		return nil, false
+	}
+	var keep func(Object) bool
+	if pt["quota"] != "" {
+		quotaUrl, err := r.serviceURL(scope, pt["service"], pt["quota_path"], region, false)
+		if err == nil {
+			keep, err = r.withinQuota(project, quotaUrl, quotaMicroversions[pt["service"]], pt["quota"])
+		}
+		if err != nil {
+			log.Warningf("Could not retrieve %s quota: %s", pt["quota"], err)
+		}
+	}
+	objects, truncated, err := r.getObjects(project, pt["name"], url, scope.Project.ID, keep)
+	if err != nil {
+		log.Warningf("Could not retrieve %s: %s", pt["name"], err)
+	}
+	return objects, truncated
+}
+
+// withinQuota - whether an object fits in the quota at all. The quota maps
+// quota resources to object fields, for example cores:vcpus. Objects are
+// checked against the quota limit rather than what remains of it, as an
+// object replacing another, such as the flavor of a resize, only needs the
+// difference between the two.
+func (r OpenstackAdapter) withinQuota(project string, quotaUrl string, header http.Header, quota string) (func(Object) bool, error) {
+	limits := make(map[string]int)
+	for _, limit := range strings.Split(quota, ",") {
+		parts := strings.SplitN(limit, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("quota %v does not name an object field", limit)
+		}
+		usage, err := r.getQuota(project, quotaUrl, header, parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if usage.Limit >= 0 {
+			limits[parts[1]] = usage.Limit
+		}
+	}
+
+	return func(object Object) bool {
+		for field, limit := range limits {
+			if size, ok := objectField(object, field); ok && size > limit {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// objectField - the size of an object in a quota limited field
+func objectField(object Object, field string) (int, bool) {
+	switch field {
+	case "vcpus":
+		return object.VCPUs, true
+	case "ram":
+		return object.RAM, true
+	}
+	return 0, false
+}
+
+// appendSentence - add a sentence to a description, ending the description
+// with a full stop first if it has none
+func appendSentence(description string, sentence string) string {
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return sentence
+	}
+	if !strings.HasSuffix(description, ".") {
+		description += "."
+	}
+	return description + " " + sentence
+}
+
+// labelSeparator - separates the label of an object from its id in an enum
+// value. The runner acts on the id after the last separator.
+const labelSeparator = " — "
+
+// objectValues - the enum values of objects, their names or, with ids, a
+// descriptive label followed by the id such as
+// "Fedora 28 (qcow2, 4 GiB) — 3f2a...". Names are not unique and do not
+// survive a resource being recreated, the id is what the runner acts on.
+func objectValues(objects []Object, ids bool) []string {
+	var values []string
+	for _, object := range objects {
+		if ids && object.ID != "" {
+			values = append(values, objectValue(object))
+			continue
+		}
+		// Some services allow unnamed objects, which can not be picked
+		// by name
+		if object.Name != "" {
+			values = append(values, object.Name)
+		}
+	}
+	return values
+}
+
+// objectValue - the label of an object followed by its id
+func objectValue(object Object) string {
+	return objectLabel(object) + labelSeparator + object.ID
+}
+
+// objectDefault - the value of a default, which may be the id or name of an
+// object offered by its label
+func objectDefault(objects []Object, def string, ids bool) string {
+	if !ids {
+		return def
+	}
+	for _, object := range objects {
+		if object.ID == def {
+			return objectValue(object)
+		}
+	}
+	for _, object := range objects {
+		if object.Name == def && object.ID != "" {
+			return objectValue(object)
+		}
+	}
+	return def
+}
+
+// objectLabel - the name of an object with its size
+func objectLabel(object Object) string {
+	var details []string
+	if object.CIDR != "" {
+		details = append(details, object.CIDR)
+	}
+	if object.DiskFormat != "" {
+		details = append(details, object.DiskFormat)
+	}
+	if object.Size >= 1<<30 {
+		details = append(details, fmt.Sprintf("%v GiB", sizeLabel(float64(object.Size)/(1<<30))))
+	} else if object.Size > 0 {
+		details = append(details, fmt.Sprintf("%v MiB", sizeLabel(float64(object.Size)/(1<<20))))
+	}
+	if object.VCPUs > 0 {
+		details = append(details, fmt.Sprintf("%v vCPU", object.VCPUs))
+	}
+	if object.RAM > 0 {
+		details = append(details, fmt.Sprintf("%v GiB RAM", sizeLabel(float64(object.RAM)/1024)))
+	}
+
+	label := object.Name
+	if label == "" {
+		label = "unnamed"
+	}
+	if len(details) > 0 {
+		label = fmt.Sprintf("%v (%v)", label, strings.Join(details, ", "))
+	}
+	return label
+}
+
+// sizeLabel - a size rounded to a tenth below ten and to a whole number above
+func sizeLabel(size float64) string {
+	if size >= 10 {
+		return strconv.FormatFloat(size, 'f', 0, 64)
+	}
+	return strings.TrimSuffix(strconv.FormatFloat(size, 'f', 1, 64), ".0")
+}
+
+// multiplePattern - match a comma separated list of the values
+func multiplePattern(values []string, required bool) string {
+	if len(values) == 0 {
+		return "^$"
+	}
+	var quoted []string
+	for _, value := range values {
+		quoted = append(quoted, regexp.QuoteMeta(value))
+	}
+	value := fmt.Sprintf("(?:%v)", strings.Join(quoted, "|"))
+	pattern := fmt.Sprintf("^%v(?:,%v)*$", value, value)
+	if !required {
+		pattern = "^$|" + pattern
+	}
+	return pattern
+}
+
+// serviceURL - resolve a path relative to the endpoint of the catalog service
+// type, or with root to the API root in front of the endpoint's version, for
+// example /swift of a radosgw /swift/v1/AUTH_x endpoint, substituting the
+// {project_id} of the scope.
+func (r OpenstackAdapter) serviceURL(scope Token, serviceType string, path string, region string, root bool) (string, error) {
+	endpoint, err := scope.Catalog.EndpointURL(serviceType, r.Interface, region)
+	if err != nil {
+		return "", err
+	}
+	if root {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return "", err
+		}
+		u.Path = apiRoot(u.Path)
+		u.RawPath = ""
+		u.RawQuery = ""
+		endpoint = strings.TrimSuffix(u.String(), "/")
+	}
+	return endpoint + strings.Replace(path, "{project_id}", scope.Project.ID, -1), nil
+}
+
+var versionSegment = regexp.MustCompile(`^v[0-9]+(\.[0-9]+)?$`)
+
+// apiRoot - the part of an endpoint path before its version segment, the
+// whole path if it has none
+func apiRoot(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if versionSegment.MatchString(segment) {
+			return strings.Join(segments[:i], "/")
+		}
+	}
+	return strings.TrimSuffix(path, "/")
+}
+
+// getQuotaRemaining - how much of a quota resource the project has left.
+// Returns false when the resource is unlimited.
+func (r OpenstackAdapter) getQuotaRemaining(project string, quotaUrl string, header http.Header, resource string) (int, bool, error) {
+	usage, err := r.getQuota(project, quotaUrl, header, resource)
+	if err != nil || usage.Limit < 0 {
+		return 0, false, err
+	}
+	remaining := usage.Limit - usage.InUse - usage.Reserved
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true, nil
+}
+
+// getQuota - the limit and usage of a quota resource, a negative limit is
+// unlimited
+func (r OpenstackAdapter) getQuota(project string, quotaUrl string, header http.Header, resource string) (quotaUsage, error) {
+	usage := quotaUsage{}
+	response, err := r.authenticatedRequestWithHeader(project, quotaUrl, "GET", nil, header)
+	if err != nil {
+		return usage, err
+	}
+	defer response.Body.Close()
+	quotaJson, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return usage, err
+	}
+
+	quotas := quotaResponse{}
+	if err := json.Unmarshal(quotaJson, &quotas); err != nil {
+		return usage, err
+	}
+	raw, ok := quotas.QuotaSet[resource]
+	if !ok {
+		return usage, fmt.Errorf("quota set has no %v", resource)
+	}
+
+	if err := json.Unmarshal(raw, &usage); err != nil {
+		// Without usage details the quota is a bare limit
+		if err := json.Unmarshal(raw, &usage.Limit); err != nil {
+			return usage, err
+		}
+	}
+	return usage, nil
+}
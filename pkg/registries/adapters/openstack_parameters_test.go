@@ -0,0 +1,166 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// catalogScope - a scope whose catalog has a public object-store endpoint
+func catalogScope(endpoint string) Token {
+	return Token{
+		Project: Project{ID: "p1", Name: "demo"},
+		Catalog: Catalog{{Type: "object-store", Endpoints: []Endpoint{{Interface: "public", URL: endpoint}}}},
+	}
+}
+
+func TestServiceURL(t *testing.T) {
+	r := OpenstackAdapter{}
+	scope := catalogScope("https://swift.example.com:8080/v1/AUTH_p1")
+
+	u, err := r.serviceURL(scope, "object-store", "/{project_id}/c", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://swift.example.com:8080/v1/AUTH_p1/p1/c" {
+		t.Errorf("unexpected url %v", u)
+	}
+	if _, err := r.serviceURL(scope, "compute", "/flavors", "", false); err == nil {
+		t.Error("expected a missing service to fail")
+	}
+}
+
+func TestServiceURLRoot(t *testing.T) {
+	r := OpenstackAdapter{}
+	endpoints := map[string]string{
+		"https://swift.example.com:8080/v1/AUTH_p1":     "https://swift.example.com:8080/info",
+		"https://rgw.example.com/swift/v1/AUTH_p1":      "https://rgw.example.com/swift/info",
+		"https://cloud.example.com/object-store/v1.0/x": "https://cloud.example.com/object-store/info",
+		"https://swift.example.com/storage":             "https://swift.example.com/storage/info",
+		"https://swift.example.com/":                    "https://swift.example.com/info",
+	}
+	for endpoint, expected := range endpoints {
+		u, err := r.serviceURL(catalogScope(endpoint), "object-store", "/info", "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u != expected {
+			t.Errorf("%v: expected %v, got %v", endpoint, expected, u)
+		}
+	}
+}
+
+func TestObjectValues(t *testing.T) {
+	objects := []Object{
+		{ID: "i1", Name: "fedora", DiskFormat: "qcow2", Size: 4 << 30},
+		{ID: "i2", Name: "fedora", Size: 512 << 20},
+		{ID: "i3"},
+		{Name: "nameless-id"},
+	}
+
+	expected := []string{"fedora (qcow2, 4 GiB) — i1", "fedora (512 MiB) — i2", "unnamed — i3", "nameless-id"}
+	if values := objectValues(objects, true); !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected labelled ids, got %v", values)
+	}
+	if values := objectValues(objects, false); strings.Join(values, ",") != "fedora,fedora,nameless-id" {
+		t.Errorf("expected names of named objects, got %v", values)
+	}
+}
+
+func TestObjectLabel(t *testing.T) {
+	labels := map[string]Object{
+		"m1.small (1 vCPU, 2 GiB RAM)":  {ID: "f1", Name: "m1.small", VCPUs: 1, RAM: 2048},
+		"m1.tiny (1 vCPU, 0.5 GiB RAM)": {ID: "f0", Name: "m1.tiny", VCPUs: 1, RAM: 512},
+		"cirros (qcow2, 12 MiB)":        {ID: "i1", Name: "cirros", DiskFormat: "qcow2", Size: 12 << 20},
+		"private":                       {ID: "n1", Name: "private"},
+		"unnamed (10.0.0.0/24)":         {ID: "s1", CIDR: "10.0.0.0/24"},
+	}
+	for expected, object := range labels {
+		if label := objectLabel(object); label != expected {
+			t.Errorf("expected %q, got %q", expected, label)
+		}
+	}
+}
+
+func TestObjectDefault(t *testing.T) {
+	objects := []Object{{ID: "f1", Name: "m1.small"}, {ID: "m1.small", Name: "other"}, {ID: "f2", Name: "m1.large"}}
+	cases := []struct {
+		def      string
+		ids      bool
+		expected string
+	}{
+		{"m1.large", true, "m1.large — f2"},
+		{"f1", true, "m1.small — f1"},
+		// An id wins over a name
+		{"m1.small", true, "other — m1.small"},
+		{"m1.large", false, "m1.large"},
+		{"missing", true, "missing"},
+	}
+	for _, c := range cases {
+		if def := objectDefault(objects, c.def, c.ids); def != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.def, c.expected, def)
+		}
+	}
+}
+
+func TestObjectNumericID(t *testing.T) {
+	page := []byte(`{"flavors":[{"id":7,"name":"small","ram":2048},{"id":"f2","name":"large"},{"name":"none"}]}`)
+	objects := OpenstackAdapter{}.parseObjects("flavors", page, "")
+	if len(objects) != 3 || objects[0].ID != "7" || objects[0].RAM != 2048 || objects[1].ID != "f2" || objects[2].ID != "" {
+		t.Errorf("expected string ids, got %v", objects)
+	}
+}
+
+func TestShareQuotaMicroversion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			writeToken(w, "token", time.Hour)
+		case "/share/v2/quota-sets/p1/detail":
+			// Manila serves quota details from microversion 2.25 on
+			if r.Header.Get("X-Openstack-Manila-Api-Version") != "2.25" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"quota_set":{"gigabytes":{"limit":100,"in_use":30,"reserved":0}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	scope := Token{
+		Project: Project{ID: "p1", Name: "demo"},
+		Catalog: Catalog{{Type: "sharev2", Endpoints: []Endpoint{{Interface: "public", URL: server.URL + "/share/v2"}}}},
+	}
+
+	var sizeType map[string]string
+	for _, pt := range parameterTypes["share"] {
+		if pt["name"] == "size" {
+			sizeType = pt
+		}
+	}
+	parameter := r.loadParameter("demo", "", scope, sizeType)
+	if parameter.Maximum == nil || *parameter.Maximum != 70 {
+		t.Errorf("expected the size to be limited to the remaining 70 GiB, got %v", parameter.Maximum)
+	}
+}
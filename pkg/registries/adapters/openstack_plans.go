@@ -0,0 +1,436 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// servicePlan - a plan of a service and the parameterTypes entries that only
+// apply to it. They come before the service parameters.
+type servicePlan struct {
+	Name        string
+	Description string
+	Metadata    map[string]interface{}
+	Parameters  []map[string]string
+	// Objects - what the plan parameters of a list type offer, by type,
+	// instead of everything the service lists
+	Objects map[string][]Object
+}
+
+// planSources - services with plans generated from the project's resources.
+// Other services have a single default plan.
+var planSources = map[string]func(OpenstackAdapter, string, string, Token) ([]servicePlan, error){
+	"vm":          OpenstackAdapter.vmPlans,
+	"database":    OpenstackAdapter.databasePlans,
+	"k8s-cluster": OpenstackAdapter.clusterPlans,
+	"dns":         OpenstackAdapter.dnsPlans,
+	"secret":      OpenstackAdapter.secretPlans,
+}
+
+var invalidPlanName = regexp.MustCompile("[^a-z0-9-]+")
+
+// planName - a plan name made of lower case letters, digits and dashes
+func planName(name string) string {
+	return strings.Trim(invalidPlanName.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// loadPlans - the generated plans of a service, if it has any
+func (r OpenstackAdapter) loadPlans(service string, project string, region string, scope Token) ([]servicePlan, error) {
+	source, ok := planSources[service]
+	if !ok {
+		return nil, nil
+	}
+	return source(r, project, region, scope)
+}
+
+type flavor struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	VCPUs      int               `json:"vcpus"`
+	RAM        int               `json:"ram"`
+	Disk       int               `json:"disk"`
+	ExtraSpecs map[string]string `json:"extra_specs"`
+}
+
+// flavorTier - the flavors of a vm plan, smallest first
+type flavorTier struct {
+	Name    string
+	Flavors []flavor
+}
+
+// vmPlans - a plan per flavor tier when tiers are configured, offering only
+// the flavors of the tier that fit in the project's quota
+func (r OpenstackAdapter) vmPlans(project string, region string, scope Token) ([]servicePlan, error) {
+	if r.FlavorTierPattern == "" && r.FlavorTierExtraSpec == "" {
+		return nil, nil
+	}
+	tiers, err := r.flavorTiers(project, region, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var flavorType map[string]string
+	for _, pt := range parameterTypes["vm"] {
+		if pt["name"] == "flavors" {
+			flavorType = pt
+		}
+	}
+	var fits func(Object) bool
+	quotaUrl, err := r.serviceURL(scope, flavorType["service"], flavorType["quota_path"], region, false)
+	if err == nil {
+		fits, err = r.withinQuota(project, quotaUrl, quotaMicroversions[flavorType["service"]], flavorType["quota"])
+	}
+	if err != nil {
+		log.Warningf("Could not retrieve %s quota: %s", flavorType["quota"], err)
+	}
+
+	var plans []servicePlan
+	for _, tier := range tiers {
+		var objects []Object
+		var bullets []string
+		for _, f := range tier.Flavors {
+			object := Object{ID: f.ID, Name: f.Name, VCPUs: f.VCPUs, RAM: f.RAM}
+			if fits != nil && !fits(object) {
+				continue
+			}
+			objects = append(objects, object)
+			bullets = append(bullets, fmt.Sprintf("%v: %v vCPU / %v GiB RAM / %v GiB disk", f.Name, f.VCPUs, gibibytes(f.RAM), f.Disk))
+		}
+		if len(objects) == 0 {
+			// A plan without flavors could never be provisioned
+			log.Warningf("Leaving out the %v tier, none of its flavors fit in the quota of %v", tier.Name, project)
+			continue
+		}
+
+		plans = append(plans, servicePlan{
+			Name:        planName(tier.Name),
+			Description: fmt.Sprintf("Provisions an Openstack %v vm instance in the %v Project of the %v Domain", tier.Name, project, r.projectDomainLabel()),
+			Metadata: map[string]interface{}{
+				"displayName": tier.Name,
+				"bullets":     bullets,
+			},
+			Parameters: []map[string]string{flavorType},
+			Objects:    map[string][]Object{"flavors": objects},
+		})
+	}
+	return plans, nil
+}
+
+// flavorTiers - the flavors grouped by tier, with the tier of the smallest
+// flavors first. Flavors outside of any tier are left out.
+func (r OpenstackAdapter) flavorTiers(project string, region string, scope Token) ([]flavorTier, error) {
+	var pattern *regexp.Regexp
+	if r.FlavorTierPattern != "" {
+		var err error
+		pattern, err = regexp.Compile(r.FlavorTierPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flavor tier pattern: %v", err)
+		}
+	}
+
+	flavorsUrl, err := r.serviceURL(scope, "compute", "/flavors/detail", region, false)
+	if err != nil {
+		return nil, err
+	}
+	flavors, err := r.listFlavors(project, flavorsUrl, extraSpecsMicroversion)
+	if isNotAcceptable(err) {
+		// Compute APIs older than the microversion
+		flavors, err = r.listFlavors(project, flavorsUrl, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tiers []*flavorTier
+	byName := make(map[string]*flavorTier)
+	for _, f := range flavors {
+		var tier string
+		if pattern != nil {
+			match := pattern.FindStringSubmatch(f.Name)
+			if len(match) > 1 {
+				tier = match[1]
+			} else if len(match) == 1 {
+				tier = match[0]
+			}
+		} else {
+			if f.ExtraSpecs == nil {
+				// Older compute APIs leave the extra specs out of the list
+				if err := r.flavorExtraSpecs(project, region, scope, &f); err != nil {
+					log.Warningf("Could not retrieve extra specs of flavor %v: %s", f.Name, err)
+				}
+			}
+			tier = f.ExtraSpecs[r.FlavorTierExtraSpec]
+		}
+		if tier == "" {
+			continue
+		}
+		if _, ok := byName[tier]; !ok {
+			byName[tier] = &flavorTier{Name: tier}
+			tiers = append(tiers, byName[tier])
+		}
+		byName[tier].Flavors = append(byName[tier].Flavors, f)
+	}
+
+	var sorted []flavorTier
+	for _, tier := range tiers {
+		sort.SliceStable(tier.Flavors, func(i, j int) bool { return smallerFlavor(tier.Flavors[i], tier.Flavors[j]) })
+		sorted = append(sorted, *tier)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return smallerFlavor(sorted[i].Flavors[0], sorted[j].Flavors[0]) })
+	return sorted, nil
+}
+
+// extraSpecsMicroversion - the compute microversion that lists flavors with
+// their extra specs
+var extraSpecsMicroversion = http.Header{
+	"X-Openstack-Nova-Api-Version": {"2.61"},
+	"Openstack-Api-Version":        {"compute 2.61"},
+}
+
+// listFlavors - the flavors of the compute API
+func (r OpenstackAdapter) listFlavors(project string, flavorsUrl string, header http.Header) ([]flavor, error) {
+	var flavors []flavor
+	_, err := r.getPagesWithHeader(project, "flavors", flavorsUrl, header, func(flavorsJson []byte) int {
+		page := struct {
+			Flavors []flavor `json:"flavors"`
+		}{}
+		json.Unmarshal(flavorsJson, &page)
+		flavors = append(flavors, page.Flavors...)
+		return len(page.Flavors)
+	})
+	return flavors, err
+}
+
+func (r OpenstackAdapter) flavorExtraSpecs(project string, region string, scope Token, f *flavor) error {
+	specsUrl, err := r.serviceURL(scope, "compute", "/flavors/"+url.PathEscape(f.ID)+"/os-extra_specs", region, false)
+	if err != nil {
+		return err
+	}
+	extraSpecs := struct {
+		ExtraSpecs map[string]string `json:"extra_specs"`
+	}{}
+	if err := r.getObject(project, specsUrl, &extraSpecs); err != nil {
+		return err
+	}
+	f.ExtraSpecs = extraSpecs.ExtraSpecs
+	return nil
+}
+
+func smallerFlavor(a flavor, b flavor) bool {
+	if a.VCPUs != b.VCPUs {
+		return a.VCPUs < b.VCPUs
+	}
+	if a.RAM != b.RAM {
+		return a.RAM < b.RAM
+	}
+	return a.Disk < b.Disk
+}
+
+// gibibytes - a size in MiB as GiB, with a fraction only when needed
+func gibibytes(mebibytes int) string {
+	return strconv.FormatFloat(float64(mebibytes)/1024, 'f', -1, 64)
+}
+
+type datastoreVersion struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Active int    `json:"active"`
+}
+
+type datastore struct {
+	ID             string             `json:"id"`
+	Name           string             `json:"name"`
+	DefaultVersion string             `json:"default_version"`
+	Versions       []datastoreVersion `json:"versions"`
+}
+
+// databasePlans - a plan per Trove datastore with its active versions
+func (r OpenstackAdapter) databasePlans(project string, region string, scope Token) ([]servicePlan, error) {
+	datastoresUrl, err := r.serviceURL(scope, "database", "/datastores", region, false)
+	if err != nil {
+		return nil, err
+	}
+	datastores := struct {
+		Datastores []datastore `json:"datastores"`
+	}{}
+	if err := r.getObject(project, datastoresUrl, &datastores); err != nil {
+		return nil, err
+	}
+
+	var plans []servicePlan
+	for _, store := range datastores.Datastores {
+		var versions []string
+		var defaultVersion string
+		for _, version := range store.Versions {
+			if version.Active == 0 {
+				continue
+			}
+			if version.ID == store.DefaultVersion {
+				defaultVersion = version.Name
+			}
+			versions = append(versions, version.Name)
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		if defaultVersion == "" {
+			defaultVersion = versions[0]
+		}
+
+		plans = append(plans, servicePlan{
+			Name:        planName(store.Name),
+			Description: fmt.Sprintf("Provisions a %v database with Trove in the %v Project of the %v Domain", store.Name, project, r.projectDomainLabel()),
+			Metadata: map[string]interface{}{
+				"displayName": store.Name,
+			},
+			Parameters: []map[string]string{
+				// A single choice, the datastore is fixed by the plan
+				{"label": "Datastore", "values": store.Name, "default": store.Name, "required": "true"},
+				{"label": "Datastore Version", "values": strings.Join(versions, ","), "default": defaultVersion, "required": "true"},
+			},
+		})
+	}
+	return plans, nil
+}
+
+type clusterTemplate struct {
+	Name           string `json:"name"`
+	COE            string `json:"coe"`
+	ServerType     string `json:"server_type"`
+	FlavorID       string `json:"flavor_id"`
+	MasterFlavorID string `json:"master_flavor_id"`
+	ImageID        string `json:"image_id"`
+}
+
+// clusterPlans - a plan per Magnum kubernetes cluster template
+func (r OpenstackAdapter) clusterPlans(project string, region string, scope Token) ([]servicePlan, error) {
+	templatesUrl, err := r.serviceURL(scope, "container-infra", "/clustertemplates", region, false)
+	if err != nil {
+		return nil, err
+	}
+	templates := struct {
+		ClusterTemplates []clusterTemplate `json:"clustertemplates"`
+	}{}
+	if err := r.getObject(project, templatesUrl, &templates); err != nil {
+		return nil, err
+	}
+
+	var plans []servicePlan
+	for _, template := range templates.ClusterTemplates {
+		if template.COE != "kubernetes" {
+			continue
+		}
+		bullets := []string{
+			fmt.Sprintf("COE: %v", template.COE),
+			fmt.Sprintf("Server type: %v", template.ServerType),
+			fmt.Sprintf("Node flavor: %v", template.FlavorID),
+		}
+		if template.MasterFlavorID != "" {
+			bullets = append(bullets, fmt.Sprintf("Master flavor: %v", template.MasterFlavorID))
+		}
+		plans = append(plans, servicePlan{
+			Name:        planName(template.Name),
+			Description: fmt.Sprintf("Provisions a Kubernetes cluster from the %v Magnum cluster template in the %v Project of the %v Domain", template.Name, project, r.projectDomainLabel()),
+			Metadata: map[string]interface{}{
+				"displayName":  template.Name,
+				"bullets":      bullets,
+				"coe":          template.COE,
+				"serverType":   template.ServerType,
+				"flavor":       template.FlavorID,
+				"masterFlavor": template.MasterFlavorID,
+			},
+			Parameters: []map[string]string{
+				// A single choice, the template is fixed by the plan
+				{"label": "Cluster Template", "values": template.Name, "default": template.Name, "required": "true"},
+			},
+		})
+	}
+	return plans, nil
+}
+
+// dnsPlans - a Designate zone, or a recordset in one of the project's zones
+// which may point at the floating IP of a vm instance
+func (r OpenstackAdapter) dnsPlans(project string, region string, scope Token) ([]servicePlan, error) {
+	return []servicePlan{
+		{
+			Name:        "zone",
+			Description: fmt.Sprintf("Provisions a Designate zone in the %v Project of the %v Domain", project, r.projectDomainLabel()),
+			Metadata: map[string]interface{}{
+				"displayName": "Zone",
+			},
+			Parameters: []map[string]string{
+				{"label": "Zone Name", "type": "string", "description": "Fully qualified, ending with a dot", "pattern": `^([a-zA-Z0-9_-]+\.)+$`, "required": "true"},
+				{"label": "Email", "type": "string", "description": "Contact address of the zone", "required": "true"},
+			},
+		},
+		{
+			Name:        "recordset",
+			Description: fmt.Sprintf("Provisions a Designate recordset in a zone of the %v Project of the %v Domain", project, r.projectDomainLabel()),
+			Metadata: map[string]interface{}{
+				"displayName": "Recordset",
+			},
+			Parameters: []map[string]string{
+				{"name": "zones", "label": "Zone", "service": "dns", "path": "/v2/zones", "required": "true"},
+				{"label": "Record Name", "type": "string", "description": "Relative to the zone, empty for the zone apex", "required": "false"},
+				{"name": "record_types", "label": "Record Type", "values": "A,AAAA,CNAME,MX,TXT,SRV,NS,PTR", "default": "A", "required": "true"},
+				{"label": "Records", "type": "string", "description": "Comma separated values, not needed when pointing at an instance", "required": "false"},
+				{"name": "instances", "label": "Point At Instance", "service": "orchestration", "path": "/stacks?tags=openstack-broker,vm", "default": "", "description": "Set an A record to the floating IP of this instance", "required": "false"},
+			},
+		},
+	}, nil
+}
+
+// secretPlans - create a Barbican secret, or reference an existing secret or
+// container
+func (r OpenstackAdapter) secretPlans(project string, region string, scope Token) ([]servicePlan, error) {
+	return []servicePlan{
+		{
+			Name:        "create",
+			Description: fmt.Sprintf("Stores a new Barbican secret in the %v Project of the %v Domain", project, r.projectDomainLabel()),
+			Metadata: map[string]interface{}{
+				"displayName": "New Secret",
+			},
+			Parameters: []map[string]string{
+				{"label": "Secret Name", "type": "string", "required": "true"},
+				{"name": "secret_types", "label": "Secret Type", "values": "certificate,passphrase,symmetric", "default": "passphrase", "required": "true"},
+				{"label": "Payload", "type": "string", "description": "Generated for passphrases and symmetric keys when empty", "display_type": "password", "required": "false"},
+				{"label": "Bit Length", "type": "integer", "default": "256", "minimum": "128", "description": "Length of a generated symmetric key", "required": "false"},
+			},
+		},
+		{
+			Name:        "reference",
+			Description: fmt.Sprintf("Binds an existing Barbican secret or container of the %v Project of the %v Domain", project, r.projectDomainLabel()),
+			Metadata: map[string]interface{}{
+				"displayName": "Existing Secret",
+			},
+			Parameters: []map[string]string{
+				{"name": "secrets", "label": "Secret", "service": "key-manager", "path": "/v1/secrets", "value": "id", "default": "", "required": "false"},
+				{"name": "containers", "label": "Container", "service": "key-manager", "path": "/v1/containers", "value": "id", "default": "", "description": "Used when no secret is picked", "required": "false"},
+			},
+		},
+	}, nil
+}
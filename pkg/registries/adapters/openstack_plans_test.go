@@ -0,0 +1,179 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFlavors = `{"flavors":[
+	{"id":"f3","name":"m1.large","vcpus":4,"ram":8192,"disk":80,"extra_specs":{"tier":"standard"}},
+	{"id":"f1","name":"m1.small","vcpus":1,"ram":2048,"disk":20,"extra_specs":{"tier":"standard"}},
+	{"id":"f4","name":"c1.huge","vcpus":32,"ram":65536,"disk":160,"extra_specs":{"tier":"compute"}},
+	{"id":"f2","name":"m1.medium","vcpus":2,"ram":4096,"disk":40,"extra_specs":{}}
+]}`
+
+var extraSpecs = regexp.MustCompile(`,"extra_specs":{[^}]*}`)
+
+// computeServer - a compute API listing testFlavors. Without microversion
+// support flavors are listed without extra specs, which are then served one
+// flavor at a time.
+func computeServer(t *testing.T, microversions bool, extraSpecRequests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/identity/v3/auth/tokens":
+			writeToken(w, "token", time.Hour)
+		case r.URL.Path == "/compute/flavors/detail":
+			if r.Header.Get("X-Openstack-Nova-Api-Version") == "" {
+				w.Write([]byte(extraSpecs.ReplaceAllString(testFlavors, "")))
+				return
+			}
+			if !microversions {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			w.Write([]byte(testFlavors))
+		case strings.HasSuffix(r.URL.Path, "/os-extra_specs"):
+			*extraSpecRequests++
+			switch strings.Split(r.URL.Path, "/")[3] {
+			case "f2":
+				w.Write([]byte(`{"extra_specs":{}}`))
+			case "f4":
+				w.Write([]byte(`{"extra_specs":{"tier":"compute"}}`))
+			default:
+				w.Write([]byte(`{"extra_specs":{"tier":"standard"}}`))
+			}
+		case r.URL.Path == "/compute/os-quota-sets/p1/detail":
+			w.Write([]byte(`{"quota_set":{"cores":{"limit":20,"in_use":0,"reserved":0},"ram":{"limit":51200,"in_use":0,"reserved":0}}}`))
+		default:
+			t.Errorf("unexpected request for %v", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func computeScope(server *httptest.Server) Token {
+	return Token{
+		Project: Project{ID: "p1", Name: "demo"},
+		Catalog: Catalog{{Type: "compute", Endpoints: []Endpoint{{Interface: "public", URL: server.URL + "/compute"}}}},
+	}
+}
+
+func tierFlavors(tier flavorTier) string {
+	var names []string
+	for _, f := range tier.Flavors {
+		names = append(names, f.Name)
+	}
+	return tier.Name + ": " + strings.Join(names, ", ")
+}
+
+func TestFlavorTiersByPattern(t *testing.T) {
+	requests := 0
+	server := computeServer(t, true, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.FlavorTierPattern = `^m1\.(\w+)$`
+
+	tiers, err := r.flavorTiers("demo", "", computeScope(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, tier := range tiers {
+		found = append(found, tierFlavors(tier))
+	}
+	expected := "small: m1.small; medium: m1.medium; large: m1.large"
+	if strings.Join(found, "; ") != expected {
+		t.Errorf("expected %v, got %v", expected, strings.Join(found, "; "))
+	}
+}
+
+func TestFlavorTiersByExtraSpec(t *testing.T) {
+	requests := 0
+	server := computeServer(t, true, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.FlavorTierExtraSpec = "tier"
+
+	tiers, err := r.flavorTiers("demo", "", computeScope(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiers) != 2 || tierFlavors(tiers[0]) != "standard: m1.small, m1.large" || tierFlavors(tiers[1]) != "compute: c1.huge" {
+		t.Errorf("expected the tiers sorted smallest first, got %v", tiers)
+	}
+	if requests != 0 {
+		t.Errorf("expected the extra specs to be listed with the flavors, got %v requests", requests)
+	}
+}
+
+func TestFlavorTiersWithoutMicroversion(t *testing.T) {
+	requests := 0
+	server := computeServer(t, false, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.FlavorTierExtraSpec = "tier"
+
+	tiers, err := r.flavorTiers("demo", "", computeScope(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiers) != 2 || tierFlavors(tiers[0]) != "standard: m1.small, m1.large" {
+		t.Errorf("expected the extra specs to be read per flavor, got %v", tiers)
+	}
+	if requests != 4 {
+		t.Errorf("expected a request per flavor without extra specs, got %v", requests)
+	}
+}
+
+func TestVmPlansSkipTiersOverQuota(t *testing.T) {
+	requests := 0
+	server := computeServer(t, true, &requests)
+	defer server.Close()
+	r := newTestAdapter(t, server)
+	r.FlavorTierExtraSpec = "tier"
+
+	plans, err := r.vmPlans("demo", "", computeScope(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// c1.huge needs more than the 20 cores of the quota
+	if len(plans) != 1 || plans[0].Name != "standard" {
+		t.Fatalf("expected only the standard plan, got %v", plans)
+	}
+	flavors := plans[0].Objects["flavors"]
+	if len(flavors) != 2 || flavors[0].ID != "f1" || flavors[1].ID != "f3" {
+		t.Errorf("expected the standard flavors, got %v", flavors)
+	}
+	parameter := r.loadParameterFrom("demo", "", computeScope(server), plans[0].Parameters[0], flavors)
+	if len(parameter.Enum) != 2 {
+		t.Errorf("expected the plan flavors to be offered, got %v", parameter.Enum)
+	}
+}
+
+func TestVmPlansWithoutTiers(t *testing.T) {
+	r := OpenstackAdapter{}
+	plans, err := r.vmPlans("demo", "", Token{})
+	if err != nil || plans != nil {
+		t.Errorf("expected no plans without tiers, got %v, %v", plans, err)
+	}
+}
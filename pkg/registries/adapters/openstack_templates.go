@@ -0,0 +1,417 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/automationbroker/bundle-lib/apb"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// templateService - the service of specs generated from HOT templates, one
+// spec per template
+const templateService = "heat"
+
+// templateSeparator - separates the template from the project in the image
+// name of a template spec
+const templateSeparator = "-template-"
+
+var templateExtensions = []string{".yaml", ".yml", ".template"}
+
+// constraintTypes - parameterTypes entries that fill the enum of a HOT
+// parameter with a custom_constraint from the project's resources
+var constraintTypes = map[string]map[string]string{
+	"nova.flavor":            {"name": "flavors", "service": "compute", "path": "/flavors", "value": "id"},
+	"nova.keypair":           {"name": "keys", "service": "compute", "path": "/os-keypairs"},
+	"glance.image":           {"name": "images", "service": "image", "path": "/v2/images", "value": "id"},
+	"neutron.network":        {"name": "networks", "service": "network", "path": "/v2.0/networks", "value": "id"},
+	"neutron.subnet":         {"name": "subnets", "service": "network", "path": "/v2.0/subnets", "value": "id"},
+	"neutron.security_group": {"name": "security_groups", "service": "network", "path": "/v2.0/security-groups"},
+	"cinder.volume":          {"name": "volumes", "service": "volumev3,block-storage", "path": "/volumes"},
+	"cinder.snapshot":        {"name": "snapshots", "service": "volumev3,block-storage", "path": "/snapshots"},
+	"cinder.vtype":           {"name": "volume_types", "service": "volumev3,block-storage", "path": "/types"},
+}
+
+type hotRange struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+type hotConstraint struct {
+	AllowedValues    []interface{} `yaml:"allowed_values"`
+	Range            *hotRange     `yaml:"range"`
+	Length           *hotRange     `yaml:"length"`
+	AllowedPattern   string        `yaml:"allowed_pattern"`
+	CustomConstraint string        `yaml:"custom_constraint"`
+}
+
+type hotParameter struct {
+	Type        string          `yaml:"type"`
+	Label       string          `yaml:"label"`
+	Description string          `yaml:"description"`
+	Default     interface{}     `yaml:"default"`
+	Hidden      bool            `yaml:"hidden"`
+	Constraints []hotConstraint `yaml:"constraints"`
+}
+
+type hotParameterGroup struct {
+	Label      string   `yaml:"label"`
+	Parameters []string `yaml:"parameters"`
+}
+
+// hotTemplate - the parts of a HOT template that describe its spec.
+// Parameters keep the order of the template.
+type hotTemplate struct {
+	Description     string              `yaml:"description"`
+	Parameters      yaml.MapSlice       `yaml:"parameters"`
+	ParameterGroups []hotParameterGroup `yaml:"parameter_groups"`
+}
+
+// templateImageService - the service part of the image name of a template
+// spec
+func templateImageService(template string) string {
+	return fmt.Sprintf("%v-%v%v", templateService, template, strings.TrimSuffix(templateSeparator, "-"))
+}
+
+// splitTemplateProject - split the project part of a template image name
+// into the template and the project
+func splitTemplateProject(name string) (string, string, error) {
+	i := strings.Index(name, templateSeparator)
+	if i < 0 {
+		return "", "", fmt.Errorf("%v does not name a template", name)
+	}
+	return name[:i], name[i+len(templateSeparator):], nil
+}
+
+// templateNames - the templates in the template directory or the project's
+// template container
+func (r OpenstackAdapter) templateNames(project string) ([]string, error) {
+	var files []string
+	switch {
+	case r.TemplateDir != "":
+		entries, err := ioutil.ReadDir(r.TemplateDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, entry.Name())
+			}
+		}
+	case r.TemplateContainer != "":
+		containerUrl, err := r.templateContainerURL(project, r.Region)
+		if err != nil {
+			return nil, err
+		}
+		var objects []Object
+		if err := r.getObject(project, containerUrl+"?format=json", &objects); err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			files = append(files, object.Name)
+		}
+	}
+
+	var names []string
+	for _, file := range files {
+		extension := filepath.Ext(file)
+		if !isTemplateExtension(extension) {
+			continue
+		}
+		name := strings.TrimSuffix(file, extension)
+		if strings.Contains(name, templateSeparator) || strings.Contains(name, "/") {
+			log.Warningf("Skipping template %v, its name can not be part of an image name", file)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func isTemplateExtension(extension string) bool {
+	for _, templateExtension := range templateExtensions {
+		if extension == templateExtension {
+			return true
+		}
+	}
+	return false
+}
+
+func (r OpenstackAdapter) templateContainerURL(project string, region string) (string, error) {
+	_, scope, err := r.getScopedToken(project)
+	if err != nil {
+		return "", err
+	}
+	return r.serviceURL(scope, "object-store", "/"+url.PathEscape(r.TemplateContainer), region, false)
+}
+
+// readTemplate - the contents and file name of a template, whichever
+// extension it has
+func (r OpenstackAdapter) readTemplate(template string, project string, region string) ([]byte, string, error) {
+	var err error
+	for _, extension := range templateExtensions {
+		var data []byte
+		file := template + extension
+		if r.TemplateDir != "" {
+			data, err = ioutil.ReadFile(filepath.Join(r.TemplateDir, file))
+		} else {
+			data, err = r.readTemplateObject(file, project, region)
+		}
+		if err == nil {
+			return data, file, nil
+		}
+	}
+	return nil, "", fmt.Errorf("unable to read template %v: %v", template, err)
+}
+
+func (r OpenstackAdapter) readTemplateObject(object string, project string, region string) ([]byte, error) {
+	containerUrl, err := r.templateContainerURL(project, region)
+	if err != nil {
+		return nil, err
+	}
+	response, err := r.authenticatedRequest(project, containerUrl+"/"+url.PathEscape(object), "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return ioutil.ReadAll(response.Body)
+}
+
+// loadTemplate - read and parse a template. Returns the file name of the
+// template along with it.
+func (r OpenstackAdapter) loadTemplate(template string, project string, region string) (hotTemplate, string, error) {
+	var hot hotTemplate
+	data, file, err := r.readTemplate(template, project, region)
+	if err != nil {
+		return hot, "", err
+	}
+	if err := yaml.Unmarshal(data, &hot); err != nil {
+		return hot, "", fmt.Errorf("unable to parse template %v: %v", template, err)
+	}
+	return hot, file, nil
+}
+
+// templateReference - the parameters telling the runner where to read the
+// template from, the file in the template directory, which has to be
+// mounted into the runner too, or in the project's template container. Each
+// has a single choice, so users can not point the runner at another
+// template.
+func (r OpenstackAdapter) templateReference(file string) []apb.ParameterDescriptor {
+	source := apb.ParameterDescriptor{
+		Name:  "template_container",
+		Title: "Template Container",
+		Enum:  []string{r.TemplateContainer},
+	}
+	if r.TemplateDir != "" {
+		source.Name = "template_dir"
+		source.Title = "Template Directory"
+		source.Enum = []string{r.TemplateDir}
+	}
+	template := apb.ParameterDescriptor{
+		Name:  "heat_template",
+		Title: "Template",
+		Enum:  []string{file},
+	}
+
+	var parameters []apb.ParameterDescriptor
+	for _, parameter := range []apb.ParameterDescriptor{template, source} {
+		parameter.Type = "enum"
+		parameter.Default = parameter.Enum[0]
+		parameter.Required = true
+		parameter.Updatable = false
+		parameter.DisplayGroup = "Heat Template"
+		parameters = append(parameters, parameter)
+	}
+	return parameters
+}
+
+// templateParameters - a parameter for each of the template parameters,
+// grouped by the template parameter_groups
+func (r OpenstackAdapter) templateParameters(hot hotTemplate, project string, region string, scope Token) ([]apb.ParameterDescriptor, error) {
+	groups := make(map[string]string)
+	var order []string
+	for _, group := range hot.ParameterGroups {
+		for _, name := range group.Parameters {
+			if _, ok := groups[name]; !ok {
+				groups[name] = group.Label
+				order = append(order, name)
+			}
+		}
+	}
+
+	hotParameters := make(map[string]hotParameter)
+	for _, item := range hot.Parameters {
+		name := fmt.Sprint(item.Key)
+		// Round trip the entry to decode it into its struct
+		data, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		var hp hotParameter
+		if err := yaml.Unmarshal(data, &hp); err != nil {
+			return nil, fmt.Errorf("unable to parse template parameter %v: %v", name, err)
+		}
+		hotParameters[name] = hp
+		if _, ok := groups[name]; !ok {
+			order = append(order, name)
+		}
+	}
+
+	// Parameters of a display group have to be next to each other
+	var parameters []apb.ParameterDescriptor
+	for _, name := range order {
+		hp, ok := hotParameters[name]
+		if !ok {
+			log.Warningf("Template parameter group refers to unknown parameter %v", name)
+			continue
+		}
+		parameter := r.templateParameter(name, hp, project, region, scope)
+		parameter.DisplayGroup = groups[name]
+		parameters = append(parameters, parameter)
+	}
+	return parameters, nil
+}
+
+// templateParameter - the parameter for a template parameter, which is
+// required when it has no default
+func (r OpenstackAdapter) templateParameter(name string, hp hotParameter, project string, region string, scope Token) apb.ParameterDescriptor {
+	title := hp.Label
+	if title == "" {
+		title = name
+	}
+	parameter := apb.ParameterDescriptor{
+		Name:        name,
+		Title:       title,
+		Type:        "string",
+		Description: hp.Description,
+		Updatable:   false,
+		Required:    hp.Default == nil,
+	}
+	switch hp.Type {
+	case "number":
+		parameter.Type = "number"
+	case "boolean":
+		parameter.Type = "boolean"
+	}
+	if hp.Hidden {
+		parameter.DisplayType = "password"
+	}
+	parameter.Default = templateDefault(hp.Type, hp.Default)
+
+	for _, constraint := range hp.Constraints {
+		if len(constraint.AllowedValues) > 0 {
+			// Enum values are strings, a number or boolean default has
+			// to be one of them as well
+			parameter.Type = "enum"
+			parameter.Enum = nil
+			for _, value := range constraint.AllowedValues {
+				parameter.Enum = append(parameter.Enum, fmt.Sprint(value))
+			}
+			if parameter.Default != nil {
+				parameter.Default = fmt.Sprint(parameter.Default)
+			}
+		}
+		if constraint.Range != nil {
+			parameter.Minimum = nilableNumber(constraint.Range.Min)
+			parameter.Maximum = nilableNumber(constraint.Range.Max)
+		}
+		if constraint.Length != nil {
+			if constraint.Length.Min != nil {
+				parameter.MinLength = int(*constraint.Length.Min)
+			}
+			if constraint.Length.Max != nil {
+				parameter.MaxLength = int(*constraint.Length.Max)
+			}
+		}
+		if constraint.AllowedPattern != "" {
+			// Heat matches the pattern against the whole value
+			parameter.Pattern = fmt.Sprintf("^(?:%v)$", constraint.AllowedPattern)
+		}
+		if constraintType, ok := constraintTypes[constraint.CustomConstraint]; ok {
+			// A template default may name the object whose id is offered
+			pt := make(map[string]string)
+			for key, value := range constraintType {
+				pt[key] = value
+			}
+			if parameter.Default != nil {
+				pt["default"] = fmt.Sprint(parameter.Default)
+			}
+			live := r.loadParameter(project, region, scope, pt)
+			parameter.Type = "enum"
+			parameter.Enum = live.Enum
+			parameter.Default = live.Default
+			if live.Description != "" {
+				parameter.Description = appendSentence(parameter.Description, live.Description)
+			}
+		}
+	}
+	return parameter
+}
+
+// templateDefault - a template default as a parameter default. Lists are
+// passed on comma separated and json values as json.
+func templateDefault(hotType string, value interface{}) interface{} {
+	switch value.(type) {
+	case nil, string, bool, int, float64:
+		return value
+	}
+	if list, ok := value.([]interface{}); ok && hotType == "comma_delimited_list" {
+		var items []string
+		for _, item := range list {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	}
+	data, err := json.Marshal(convertYAML(value))
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// convertYAML - yaml.v2 decodes maps with interface keys, which json can not
+// encode
+func convertYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for key, item := range v {
+			m[fmt.Sprint(key)] = convertYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertYAML(item)
+		}
+	}
+	return value
+}
+
+func nilableNumber(value *float64) *apb.NilableNumber {
+	if value == nil {
+		return nil
+	}
+	number := apb.NilableNumber(*value)
+	return &number
+}
@@ -0,0 +1,257 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/apb"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const testTemplate = `
+heat_template_version: 2018-08-31
+description: A web server
+parameter_groups:
+- label: Server
+  parameters:
+  - size
+  - port
+parameters:
+  name:
+    type: string
+    label: Server Name
+    constraints:
+    - length: {min: 3, max: 20}
+    - allowed_pattern: "[a-z]+"
+  password:
+    type: string
+    hidden: true
+    default: changeme
+  size:
+    type: number
+    default: 2
+    constraints:
+    - allowed_values: [1, 2, 4]
+  port:
+    type: number
+    default: 8080
+    constraints:
+    - range: {min: 1024, max: 65535}
+  debug:
+    type: boolean
+    default: false
+  zones:
+    type: comma_delimited_list
+    default: [nova, other]
+  metadata:
+    type: json
+    default: {role: web}
+`
+
+func parseTestTemplate(t *testing.T) hotTemplate {
+	var hot hotTemplate
+	if err := yaml.Unmarshal([]byte(testTemplate), &hot); err != nil {
+		t.Fatal(err)
+	}
+	return hot
+}
+
+func templateParameterByName(parameters []apb.ParameterDescriptor, name string) apb.ParameterDescriptor {
+	for _, parameter := range parameters {
+		if parameter.Name == name {
+			return parameter
+		}
+	}
+	return apb.ParameterDescriptor{}
+}
+
+func TestTemplateParametersOrder(t *testing.T) {
+	r := OpenstackAdapter{}
+	parameters, err := r.templateParameters(parseTestTemplate(t), "demo", "", Token{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, parameter := range parameters {
+		names = append(names, parameter.Name)
+	}
+	// Grouped parameters first, then the rest in template order
+	expected := []string{"size", "port", "name", "password", "debug", "zones", "metadata"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+	if parameters[0].DisplayGroup != "Server" || parameters[1].DisplayGroup != "Server" || parameters[2].DisplayGroup != "" {
+		t.Errorf("expected the Server group on size and port only, got %+v", parameters[:3])
+	}
+}
+
+func TestTemplateParameterConversion(t *testing.T) {
+	r := OpenstackAdapter{}
+	parameters, err := r.templateParameters(parseTestTemplate(t), "demo", "", Token{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := templateParameterByName(parameters, "name")
+	if name.Title != "Server Name" || name.Type != "string" || !name.Required {
+		t.Errorf("expected a required string titled by its label, got %+v", name)
+	}
+	if name.MinLength != 3 || name.MaxLength != 20 || name.Pattern != "^(?:[a-z]+)$" {
+		t.Errorf("expected the length and anchored pattern constraints, got %+v", name)
+	}
+
+	password := templateParameterByName(parameters, "password")
+	if password.DisplayType != "password" || password.Required || password.Default != "changeme" {
+		t.Errorf("expected an optional hidden parameter, got %+v", password)
+	}
+
+	port := templateParameterByName(parameters, "port")
+	if port.Type != "number" || port.Minimum == nil || *port.Minimum != 1024 || port.Maximum == nil || *port.Maximum != 65535 {
+		t.Errorf("expected a number with the range constraint, got %+v", port)
+	}
+
+	debug := templateParameterByName(parameters, "debug")
+	if debug.Type != "boolean" || debug.Default != false {
+		t.Errorf("expected a boolean defaulting to false, got %+v", debug)
+	}
+
+	zones := templateParameterByName(parameters, "zones")
+	if zones.Default != "nova,other" {
+		t.Errorf("expected a comma separated default, got %#v", zones.Default)
+	}
+
+	metadata := templateParameterByName(parameters, "metadata")
+	if metadata.Default != `{"role":"web"}` {
+		t.Errorf("expected a json default, got %#v", metadata.Default)
+	}
+}
+
+func TestTemplateAllowedValuesDefault(t *testing.T) {
+	r := OpenstackAdapter{}
+	parameters, err := r.templateParameters(parseTestTemplate(t), "demo", "", Token{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := templateParameterByName(parameters, "size")
+	if size.Type != "enum" || !reflect.DeepEqual(size.Enum, []string{"1", "2", "4"}) {
+		t.Errorf("expected the allowed values as an enum, got %+v", size)
+	}
+	// The default has to be one of the enum values, not the number 2
+	if size.Default != "2" {
+		t.Errorf("expected the default to match an enum value, got %#v", size.Default)
+	}
+}
+
+func TestTemplateNamesAndReference(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openstack-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{"web.yaml", "db.template", "README.md", "bad-template-name.yaml"} {
+		ioutil.WriteFile(filepath.Join(dir, file), []byte(testTemplate), 0600)
+	}
+	r := OpenstackAdapter{TemplateDir: dir}
+
+	names, err := r.templateNames("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"db", "web"}) {
+		t.Errorf("expected the db and web templates, got %v", names)
+	}
+
+	hot, file, err := r.loadTemplate("db", "demo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file != "db.template" || hot.Description != "A web server" {
+		t.Errorf("expected db.template to be parsed, got %v %+v", file, hot)
+	}
+
+	reference := r.templateReference(file)
+	if len(reference) != 2 {
+		t.Fatalf("expected the template and its directory, got %+v", reference)
+	}
+	for i, expected := range map[int][]string{0: {"heat_template", "db.template"}, 1: {"template_dir", dir}} {
+		parameter := reference[i]
+		if parameter.Name != expected[0] || parameter.Type != "enum" || !reflect.DeepEqual(parameter.Enum, expected[1:]) || parameter.Default != expected[1] {
+			t.Errorf("expected %v to be the only choice of %v, got %+v", expected[1], expected[0], parameter)
+		}
+		if parameter.Updatable || !parameter.Required {
+			t.Errorf("expected %v to be required and not updatable", parameter.Name)
+		}
+	}
+}
+
+func TestTemplateImageName(t *testing.T) {
+	r := OpenstackAdapter{}
+	name := imageName(templateImageService("web"), "demo", "")
+	service, project, _, err := r.parseImageName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, project, err := splitTemplateProject(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service != templateService || template != "web" || project != "demo" {
+		t.Errorf("expected the web template of demo from %v, got %v %v %v", name, service, template, project)
+	}
+}
+
+func TestTemplateConstraintIDs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			writeToken(w, "token", time.Hour)
+		case "/compute/flavors":
+			w.Write([]byte(`{"flavors":[{"id":"f1","name":"m1.small"},{"id":"f2","name":"m1.large"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	r := newTestAdapter(t, server)
+
+	hp := hotParameter{
+		Type:        "string",
+		Description: "Server flavor",
+		Default:     "m1.large",
+		Constraints: []hotConstraint{{CustomConstraint: "nova.flavor"}},
+	}
+	parameter := r.templateParameter("flavor", hp, "demo", "", computeScope(server))
+	if !reflect.DeepEqual(parameter.Enum, []string{"m1.small — f1", "m1.large — f2"}) {
+		t.Errorf("expected the labelled flavor ids, got %v", parameter.Enum)
+	}
+	if parameter.Default != "m1.large — f2" {
+		t.Errorf("expected the named default to become its labelled id, got %v", parameter.Default)
+	}
+	if parameter.Description != "Server flavor" {
+		t.Errorf("expected the template description, got %q", parameter.Description)
+	}
+}
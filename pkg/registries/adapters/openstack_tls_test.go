@@ -0,0 +1,189 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePEM - write a PEM block to a file of the directory
+func writePEM(t *testing.T, dir string, name string, blockType string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeClientCert - write a self-signed client certificate and its key
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "openstack-broker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, dir, "client.crt", "CERTIFICATE", cert), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyBytes)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "openstack-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newTLSServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+}
+
+func TestNewHTTPClientVerifiesByDefault(t *testing.T) {
+	server := newTLSServer()
+	defer server.Close()
+
+	client, err := NewHTTPClient(TLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Timeout != requestTimeout {
+		t.Errorf("expected a %v timeout, got %v", requestTimeout, client.Timeout)
+	}
+
+	r := OpenstackAdapter{HTTPClient: client}
+	_, err = r.openstackRequest(server.URL, "GET", nil, "")
+	if err == nil {
+		t.Fatal("expected the self-signed certificate to be rejected")
+	}
+	if !strings.Contains(err.Error(), "set ca_file") {
+		t.Errorf("expected the verification failure to be explained, got %v", err)
+	}
+}
+
+func TestNewHTTPClientTrustsCAFile(t *testing.T) {
+	server := newTLSServer()
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ca := writePEM(t, dir, "ca.crt", "CERTIFICATE", server.Certificate().Raw)
+	client, err := NewHTTPClient(TLSConfig{CAFile: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := OpenstackAdapter{HTTPClient: client}
+	response, err := r.openstackRequest(server.URL, "GET", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+}
+
+func TestNewHTTPClientInsecure(t *testing.T) {
+	server := newTLSServer()
+	defer server.Close()
+
+	client, err := NewHTTPClient(TLSConfig{Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := OpenstackAdapter{HTTPClient: client}
+	response, err := r.openstackRequest(server.URL, "GET", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+}
+
+func TestNewHTTPClientPresentsClientCert(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cert, key := writeClientCert(t, dir)
+	client, err := NewHTTPClient(TLSConfig{ClientCert: cert, ClientKey: key, Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := OpenstackAdapter{HTTPClient: client}
+	response, err := r.openstackRequest(server.URL, "GET", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+}
+
+func TestNewHTTPClientErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	notPEM := filepath.Join(dir, "ca.txt")
+	ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600)
+	cert, _ := writeClientCert(t, dir)
+
+	configs := map[string]TLSConfig{
+		"missing ca_file":      {CAFile: filepath.Join(dir, "missing.crt")},
+		"ca_file without PEM":  {CAFile: notPEM},
+		"client_cert only":     {ClientCert: cert},
+		"client_key only":      {ClientKey: cert},
+		"mismatched key pair":  {ClientCert: cert, ClientKey: notPEM},
+		"missing client files": {ClientCert: filepath.Join(dir, "missing.crt"), ClientKey: filepath.Join(dir, "missing.key")},
+	}
+	for name, config := range configs {
+		if _, err := NewHTTPClient(config); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
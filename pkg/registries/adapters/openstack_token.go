@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenRenewBefore - how long before expiry a cached token is renewed
+const tokenRenewBefore = 5 * time.Minute
+
+// TokenManager - caches Keystone tokens by scope so catalog refreshes reuse
+// them until shortly before they expire. Safe for concurrent use.
+type TokenManager struct {
+	mutex   sync.Mutex
+	entries map[string]*tokenEntry
+}
+
+type tokenEntry struct {
+	mutex sync.Mutex
+	id    string
+	token Token
+}
+
+// NewTokenManager - create an empty token cache
+func NewTokenManager() *TokenManager {
+	return &TokenManager{entries: map[string]*tokenEntry{}}
+}
+
+// Get - return the cached token for the scope, calling issue for a new one
+// if there is none or it is about to expire. Concurrent callers for the same
+// scope wait for a single issue call.
+func (m *TokenManager) Get(scope string, issue func() (string, Token, error)) (string, Token, error) {
+	if m == nil {
+		return issue()
+	}
+
+	m.mutex.Lock()
+	entry, ok := m.entries[scope]
+	if !ok {
+		entry = &tokenEntry{}
+		m.entries[scope] = entry
+	}
+	m.mutex.Unlock()
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.id != "" && time.Now().Add(tokenRenewBefore).Before(entry.token.ExpiresAt) {
+		return entry.id, entry.token, nil
+	}
+
+	id, token, err := issue()
+	if err != nil {
+		return "", Token{}, err
+	}
+	entry.id = id
+	entry.token = token
+	return id, token, nil
+}
+
+// Invalidate - drop the cached token for the scope if it is still the given
+// token, so a token rejected by a service is not handed out again.
+func (m *TokenManager) Invalidate(scope string, id string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	entry, ok := m.entries[scope]
+	m.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	if entry.id == id {
+		entry.id = ""
+		entry.token = Token{}
+	}
+}
@@ -0,0 +1,254 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package adapters
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/registries/adapters"
+)
+
+// newTestAdapter - an adapter authenticating with a password against the
+// Keystone of the test server
+func newTestAdapter(t *testing.T, server *httptest.Server) OpenstackAdapter {
+	u, err := url.Parse(server.URL + "/identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return OpenstackAdapter{
+		Config: adapters.Configuration{URL: u, User: "user", Pass: "secret"},
+		Tokens: NewTokenManager(),
+	}
+}
+
+// writeToken - answer a token request with the token id, expiring after
+// the duration
+func writeToken(w http.ResponseWriter, id string, expiresIn time.Duration) {
+	w.Header().Set("X-Subject-Token", id)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"token":{"expires_at":%q,"project":{"id":"p1","name":"demo"}}}`,
+		time.Now().Add(expiresIn).UTC().Format(time.RFC3339))
+}
+
+func TestTokenManagerReusesToken(t *testing.T) {
+	m := NewTokenManager()
+	issued := 0
+	issue := func() (string, Token, error) {
+		issued++
+		return fmt.Sprintf("token-%v", issued), Token{ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		id, _, err := m.Get("demo", issue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "token-1" {
+			t.Errorf("expected the cached token-1, got %v", id)
+		}
+	}
+	if issued != 1 {
+		t.Errorf("expected one token to be issued, got %v", issued)
+	}
+}
+
+func TestTokenManagerRenewsExpiringToken(t *testing.T) {
+	m := NewTokenManager()
+	issued := 0
+	issue := func() (string, Token, error) {
+		issued++
+		// Inside the renewal window
+		return fmt.Sprintf("token-%v", issued), Token{ExpiresAt: time.Now().Add(tokenRenewBefore / 2)}, nil
+	}
+
+	m.Get("demo", issue)
+	id, _, err := m.Get("demo", issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "token-2" {
+		t.Errorf("expected the expiring token to be renewed, got %v", id)
+	}
+}
+
+func TestTokenManagerScopes(t *testing.T) {
+	m := NewTokenManager()
+	issue := func(id string) func() (string, Token, error) {
+		return func() (string, Token, error) {
+			return id, Token{ExpiresAt: time.Now().Add(time.Hour)}, nil
+		}
+	}
+
+	m.Get("demo", issue("demo-token"))
+	m.Get("other", issue("other-token"))
+	if id, _, _ := m.Get("demo", issue("unexpected")); id != "demo-token" {
+		t.Errorf("expected demo-token, got %v", id)
+	}
+	if id, _, _ := m.Get("other", issue("unexpected")); id != "other-token" {
+		t.Errorf("expected other-token, got %v", id)
+	}
+}
+
+func TestTokenManagerDoesNotCacheErrors(t *testing.T) {
+	m := NewTokenManager()
+	_, _, err := m.Get("demo", func() (string, Token, error) {
+		return "", Token{}, fmt.Errorf("keystone is down")
+	})
+	if err == nil {
+		t.Fatal("expected the issue error")
+	}
+
+	id, _, err := m.Get("demo", func() (string, Token, error) {
+		return "token", Token{ExpiresAt: time.Now().Add(time.Hour)}, nil
+	})
+	if err != nil || id != "token" {
+		t.Errorf("expected a new token after an error, got %q, %v", id, err)
+	}
+}
+
+func TestTokenManagerInvalidate(t *testing.T) {
+	m := NewTokenManager()
+	issued := 0
+	issue := func() (string, Token, error) {
+		issued++
+		return fmt.Sprintf("token-%v", issued), Token{ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+
+	m.Get("demo", issue)
+	// A token that has already been replaced is left alone
+	m.Invalidate("demo", "token-0")
+	if id, _, _ := m.Get("demo", issue); id != "token-1" {
+		t.Errorf("expected token-1 to survive invalidating another token, got %v", id)
+	}
+
+	m.Invalidate("demo", "token-1")
+	if id, _, _ := m.Get("demo", issue); id != "token-2" {
+		t.Errorf("expected a new token after invalidating, got %v", id)
+	}
+}
+
+func TestTokenManagerConcurrentGet(t *testing.T) {
+	m := NewTokenManager()
+	var mutex sync.Mutex
+	issued := 0
+	issue := func() (string, Token, error) {
+		mutex.Lock()
+		issued++
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		return "token", Token{ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Get("demo", issue)
+		}()
+	}
+	wg.Wait()
+	if issued != 1 {
+		t.Errorf("expected concurrent callers to share one token, got %v issued", issued)
+	}
+}
+
+func TestNilTokenManagerIssuesEveryTime(t *testing.T) {
+	var m *TokenManager
+	issued := 0
+	issue := func() (string, Token, error) {
+		issued++
+		return "token", Token{ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+	m.Get("demo", issue)
+	m.Get("demo", issue)
+	m.Invalidate("demo", "token")
+	if issued != 2 {
+		t.Errorf("expected a token per call without a cache, got %v", issued)
+	}
+}
+
+func TestAuthenticatedRequestRetriesRejectedToken(t *testing.T) {
+	issued := 0
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			issued++
+			writeToken(w, fmt.Sprintf("token-%v", issued), time.Hour)
+		case "/compute/flavors":
+			seen = append(seen, r.Header.Get("X-Auth-Token"))
+			// The first token has been revoked
+			if r.Header.Get("X-Auth-Token") == "token-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"flavors":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	r := newTestAdapter(t, server)
+
+	response, err := r.authenticatedRequest("demo", server.URL+"/compute/flavors", "GET", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if issued != 2 {
+		t.Errorf("expected the rejected token to be replaced, got %v tokens issued", issued)
+	}
+	if len(seen) != 2 || seen[0] != "token-1" || seen[1] != "token-2" {
+		t.Errorf("expected a retry with the new token, got %v", seen)
+	}
+	if id, _, _ := r.getScopedToken("demo"); id != "token-2" {
+		t.Errorf("expected the new token to be cached, got %v", id)
+	}
+}
+
+func TestAuthenticatedRequestRetriesOnce(t *testing.T) {
+	issued := 0
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			issued++
+			writeToken(w, fmt.Sprintf("token-%v", issued), time.Hour)
+		default:
+			requests++
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	r := newTestAdapter(t, server)
+
+	_, err := r.authenticatedRequest("demo", server.URL+"/compute/flavors", "GET", nil)
+	if !isUnauthorized(err) {
+		t.Errorf("expected the second rejection to be returned, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected a single retry, got %v requests", requests)
+	}
+}